@@ -0,0 +1,526 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+)
+
+// decodeFrames decodes the ANMF frames of c concurrently, with up to threads wasm module
+// instances, and composites them on the canvas the same way WebPAnimDecoder does.
+func decodeFrames(c *container, threads int, mode ColorMode) (*WEBP, error) {
+	frames := make([]*image.RGBA, len(c.frames))
+	errs := make([]error, len(c.frames))
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, threads)
+
+	for i := range c.frames {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+
+			bs := c.frames[i].bitstream()
+			if bs == nil {
+				errs[i] = ErrDecode
+				return
+			}
+
+			ret, _, err := decode(bytes.NewReader(bs), false, false, ModeRGBA, 1)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			img := ret.Image[0].(*image.RGBA)
+			if img.Rect.Dx() != c.frames[i].width || img.Rect.Dy() != c.frames[i].height {
+				errs[i] = ErrDecode
+				return
+			}
+
+			frames[i] = img
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := &WEBP{
+		Image:      make([]image.Image, 0, len(frames)),
+		Delay:      make([]int, 0, len(frames)),
+		LoopCount:  c.loopCount,
+		Background: argbToColor(c.bgcolor),
+	}
+
+	stride := c.width * 4
+	disposed := make([]byte, stride*c.height) // previous canvas, after its dispose method
+
+	for i, f := range c.frames {
+		canvas := make([]byte, len(disposed))
+		copy(canvas, disposed)
+
+		src := frames[i]
+		for y := 0; y < f.height; y++ {
+			off := (f.y+y)*stride + f.x*4
+			row := canvas[off : off+f.width*4]
+			copy(row, src.Pix[y*src.Stride:y*src.Stride+f.width*4])
+
+			if f.blend && i > 0 {
+				blendRowPremult(row, disposed[off:off+f.width*4])
+			}
+		}
+
+		copy(disposed, canvas)
+		if f.dispose {
+			for y := 0; y < f.height; y++ {
+				off := (f.y+y)*stride + f.x*4
+				clear(disposed[off : off+f.width*4])
+			}
+		}
+
+		img := &image.RGBA{Pix: canvas, Stride: stride, Rect: image.Rect(0, 0, c.width, c.height)}
+
+		ret.Image = append(ret.Image, mode.fromRGBA(img))
+		ret.Delay = append(ret.Delay, f.duration)
+	}
+
+	return ret, nil
+}
+
+// blendRowPremult blends the premultiplied src pixels over dst in place, bit-exact with libwebp's BlendPixelRowPremult.
+func blendRowPremult(src, dst []byte) {
+	for i := 0; i < len(src); i += 4 {
+		a := uint32(src[i+3])
+		if a == 0xff {
+			continue
+		}
+
+		s := binary.LittleEndian.Uint32(src[i:])
+		d := binary.LittleEndian.Uint32(dst[i:])
+
+		// ChannelwiseMultiply(dst, 256-a): scale each channel by (256-a)/256.
+		scale := 256 - a
+		rb := ((d & 0x00ff00ff) * scale) >> 8
+		ag := ((d >> 8) & 0x00ff00ff) * scale
+
+		binary.LittleEndian.PutUint32(src[i:], s+((rb&0x00ff00ff)|(ag&^0x00ff00ff)))
+	}
+}
+
+// canvas returns the canvas size for EncodeAll and whether frames are placed on it by offset,
+// which is the case when any of Config, Offset or differing frame sizes is given.
+func (w *WEBP) canvas() (width, height int, placed bool) {
+	if w.Config.Width > 0 && w.Config.Height > 0 {
+		return w.Config.Width, w.Config.Height, true
+	}
+
+	b := w.Image[0].Bounds()
+	width, height = b.Dx(), b.Dy()
+
+	placed = len(w.Offset) > 0
+	for _, img := range w.Image[1:] {
+		if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+			placed = true
+		}
+	}
+
+	if !placed {
+		return width, height, false
+	}
+
+	width, height = 0, 0
+	for i := range w.Image {
+		r := w.frameRect(i)
+		width = max(width, r.Max.X)
+		height = max(height, r.Max.Y)
+	}
+
+	return width, height, true
+}
+
+// frameRect is the rectangle frame i covers on the canvas.
+func (w *WEBP) frameRect(i int) image.Rectangle {
+	b := w.Image[i].Bounds()
+	if i < len(w.Offset) {
+		return b.Sub(b.Min).Add(w.Offset[i])
+	}
+
+	return b
+}
+
+// animOptions are the animation-level encoder parameters of EncodeAll (WebPAnimEncoderOptions).
+type animOptions struct {
+	loopCount    int
+	bgcolor      uint32 // ARGB, i.e. the ANIM chunk bytes in little-endian order
+	kmin, kmax   int
+	minimizeSize bool
+	allowMixed   bool
+}
+
+// frameConfig holds the WebPConfig fields applied to a frame.
+type frameConfig struct {
+	quality  int
+	method   int
+	lossless bool
+	exact    bool
+}
+
+// frameConfigOf returns the frame config of o with quality and method clamped as Encode does.
+func frameConfigOf(o Options) frameConfig {
+	c := frameConfig{quality: o.Quality, method: o.Method, lossless: o.Lossless, exact: o.Exact}
+
+	if c.quality <= 0 {
+		c.quality = DefaultQuality
+	} else if c.quality > 100 {
+		c.quality = 100
+	}
+
+	if c.method < 0 {
+		c.method = DefaultMethod
+	} else if c.method > 6 {
+		c.method = 6
+	}
+
+	return c
+}
+
+// apply returns c with the settings of f that are set, clamped as Encode does.
+func (f FrameOptions) apply(c frameConfig) frameConfig {
+	if f.Quality != 0 {
+		c.quality = min(max(f.Quality, 1), 100)
+	}
+
+	if f.Method != nil {
+		c.method = min(max(*f.Method, 0), 6)
+	}
+
+	if f.Lossless != nil {
+		c.lossless = *f.Lossless
+	}
+
+	if f.Exact != nil {
+		c.exact = *f.Exact
+	}
+
+	return c
+}
+
+// needsFrameEncoder reports whether the options go beyond what the wasm encode_animation export takes.
+func (a animOptions) needsFrameEncoder() bool {
+	return a.kmin != 0 || a.kmax != 0 || a.minimizeSize || a.allowMixed
+}
+
+// keyframes returns the sanitized key-frame distance the same way libwebp's SanitizeEncoderOptions does;
+// kmax 0 disables key-frame insertion and kmax 1 makes every frame a key frame.
+func (a animOptions) keyframes() (kmin, kmax int) {
+	kmin, kmax = a.kmin, a.kmax
+	if a.minimizeSize || kmax <= 0 {
+		return 0, 0
+	}
+
+	if kmax == 1 {
+		return 0, 1
+	}
+
+	if kmin >= kmax {
+		kmin = kmax - 1
+	} else if limit := kmax/2 + 1; kmin < limit && limit < kmax {
+		kmin = limit
+	}
+
+	return kmin, kmax
+}
+
+// colorToARGB converts c to the ARGB value of the ANIM background color; nil is libwebp's default white.
+func colorToARGB(c color.Color) uint32 {
+	if c == nil {
+		return 0xffffffff
+	}
+
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+
+	return uint32(n.A)<<24 | uint32(n.R)<<16 | uint32(n.G)<<8 | uint32(n.B)
+}
+
+// argbToColor is the inverse of colorToARGB.
+func argbToColor(v uint32) color.NRGBA {
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: uint8(v >> 24)}
+}
+
+// animInfo fills the loop count and background color of ret from the ANIM chunk of data, if any.
+func animInfo(ret *WEBP, data []byte) {
+	c, err := parseContainer(data)
+	if err != nil || c.flags&flagAnimation == 0 {
+		return
+	}
+
+	ret.LoopCount = c.loopCount
+	ret.Background = argbToColor(c.bgcolor)
+}
+
+// setAnimParams rewrites the background color and loop count of the ANIM chunk in data.
+func setAnimParams(data []byte, bgcolor uint32, loopCount int) error {
+	c, err := parseContainer(data)
+	if err != nil {
+		return err
+	}
+
+	for _, ch := range c.chunks {
+		if ch.fourcc == "ANIM" {
+			binary.LittleEndian.PutUint32(ch.data[0:4], bgcolor)
+			binary.LittleEndian.PutUint16(ch.data[4:6], loopCount16(loopCount))
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: no ANIM chunk", ErrEncode)
+}
+
+// loopCount16 clamps n to the 16-bit loop count of the ANIM chunk.
+func loopCount16(n int) uint16 {
+	return uint16(min(max(n, 0), 0xffff))
+}
+
+// maxFrameDuration is the largest duration of an ANMF frame, in milliseconds.
+const maxFrameDuration = 1<<24 - 1
+
+// encodedFrame is an ANMF frame produced by encodeFrames.
+type encodedFrame struct {
+	rect     image.Rectangle
+	duration int
+	blend    bool
+	alpha    bool
+	chunks   []byte // ALPH+VP8 or VP8L chunks
+}
+
+// encodeFrames is the Go-side animation encoder used on the wasm backend for the options the
+// encode_animation export does not take, including a config per frame. Each frame is compared with the previous one and
+// encoded, with the wasm single-image encoder, either as the changed sub-rectangle or as a
+// full key frame, following the kmin/kmax, minimize-size and mixed-mode rules of WebPAnimEncoder.
+// Frames identical to their predecessor extend its duration, up to the 24-bit ANMF limit. A frame costs up to four encodes
+// (sub-rectangle and key frame, each lossy and lossless with allowMixed) instead of one.
+func encodeFrames(frames []*image.NRGBA, delays []int, anim animOptions, configs []frameConfig) ([]byte, error) {
+	width, height := frames[0].Rect.Dx(), frames[0].Rect.Dy()
+	canvas := image.Rect(0, 0, width, height)
+	kmin, kmax := anim.keyframes()
+
+	out := make([]encodedFrame, 0, len(frames))
+	sinceKey := 0
+
+	for i, cur := range frames {
+		var candidates []encodedFrame
+
+		config := configs[i]
+
+		key := i == 0 || kmax == 1 || (kmax > 0 && sinceKey+1 >= kmax)
+		tryKey := key || (kmax > 0 && sinceKey+1 >= kmin)
+
+		if !key {
+			rect := diffRect(frames[i-1], cur)
+			if rect.Empty() {
+				prev := &out[len(out)-1]
+				prev.duration = min(prev.duration+delays[i], maxFrameDuration)
+				sinceKey++
+
+				continue
+			}
+
+			sub, err := encodeCandidates(cur, nil, rect, false, anim.allowMixed, config)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, sub...)
+
+			if anim.minimizeSize && opaqueChanges(frames[i-1], cur, rect) {
+				sub, err := encodeCandidates(cur, frames[i-1], rect, true, anim.allowMixed, config)
+				if err != nil {
+					return nil, err
+				}
+				candidates = append(candidates, sub...)
+			}
+		}
+
+		if tryKey {
+			full, err := encodeCandidates(cur, nil, canvas, false, anim.allowMixed, config)
+			if err != nil {
+				return nil, err
+			}
+
+			for j := range full {
+				full[j].rect = canvas
+			}
+			candidates = append(candidates, full...)
+		}
+
+		best := candidates[0]
+		for _, c := range candidates[1:] {
+			if len(c.chunks) < len(best.chunks) {
+				best = c
+			}
+		}
+
+		if best.rect == canvas && !best.blend {
+			sinceKey = 0
+		} else {
+			sinceKey++
+		}
+
+		best.duration = delays[i]
+		out = append(out, best)
+	}
+
+	flags := byte(flagAnimation)
+	for _, f := range out {
+		if f.alpha {
+			flags |= flagAlpha
+		}
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = flags
+	store24(vp8x[4:], uint32(width-1))
+	store24(vp8x[7:], uint32(height-1))
+
+	params := make([]byte, 6)
+	binary.LittleEndian.PutUint32(params[0:4], anim.bgcolor)
+	binary.LittleEndian.PutUint16(params[4:6], loopCount16(anim.loopCount))
+
+	data := appendChunk(nil, "VP8X", vp8x)
+	data = appendChunk(data, "ANIM", params)
+
+	for _, f := range out {
+		hdr := make([]byte, 16, 16+len(f.chunks))
+		store24(hdr[0:], uint32(f.rect.Min.X/2))
+		store24(hdr[3:], uint32(f.rect.Min.Y/2))
+		store24(hdr[6:], uint32(f.rect.Dx()-1))
+		store24(hdr[9:], uint32(f.rect.Dy()-1))
+		store24(hdr[12:], uint32(f.duration))
+		if !f.blend {
+			hdr[15] = 0x02
+		}
+
+		data = appendChunk(data, "ANMF", append(hdr, f.chunks...))
+	}
+
+	return riffWrap(data), nil
+}
+
+// encodeCandidates encodes the rect of cur, lossy and/or lossless depending on allowMixed.
+// With blend, pixels equal to prev are made transparent so they show the previous canvas.
+func encodeCandidates(cur, prev *image.NRGBA, rect image.Rectangle, blend, allowMixed bool, config frameConfig) ([]encodedFrame, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	alpha := false
+
+	for y := 0; y < rect.Dy(); y++ {
+		so := cur.PixOffset(rect.Min.X, rect.Min.Y+y)
+		row := img.Pix[y*img.Stride : y*img.Stride+rect.Dx()*4]
+		copy(row, cur.Pix[so:so+len(row)])
+
+		if blend {
+			po := prev.PixOffset(rect.Min.X, rect.Min.Y+y)
+			for x := 0; x < len(row); x += 4 {
+				if bytes.Equal(row[x:x+4], prev.Pix[po+x:po+x+4]) {
+					clear(row[x : x+4])
+				}
+			}
+		}
+
+		for x := 3; x < len(row) && !alpha; x += 4 {
+			alpha = row[x] != 0xff
+		}
+	}
+
+	modes := []bool{config.lossless}
+	if allowMixed {
+		modes = []bool{true, false}
+	}
+
+	candidates := make([]encodedFrame, 0, len(modes))
+	for _, lossless := range modes {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, config.quality, config.method, lossless, config.exact); err != nil {
+			return nil, err
+		}
+
+		c, err := parseContainer(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		var chunks []byte
+		for _, ch := range c.chunks {
+			switch ch.fourcc {
+			case "ALPH", "VP8 ", "VP8L":
+				chunks = appendChunk(chunks, ch.fourcc, ch.data)
+			}
+		}
+
+		candidates = append(candidates, encodedFrame{rect: rect, blend: blend, alpha: alpha, chunks: chunks})
+	}
+
+	return candidates, nil
+}
+
+// diffRect returns the bounding box of the pixels that differ between a and b, with its
+// origin snapped to even coordinates as required by ANMF offsets.
+func diffRect(a, b *image.NRGBA) image.Rectangle {
+	w, h := b.Rect.Dx(), b.Rect.Dy()
+	r := image.Rectangle{Min: image.Pt(w, h)}
+
+	for y := 0; y < h; y++ {
+		ra := a.Pix[y*a.Stride : y*a.Stride+w*4]
+		rb := b.Pix[y*b.Stride : y*b.Stride+w*4]
+		if bytes.Equal(ra, rb) {
+			continue
+		}
+
+		x0 := 0
+		for bytes.Equal(ra[x0*4:x0*4+4], rb[x0*4:x0*4+4]) {
+			x0++
+		}
+
+		x1 := w
+		for bytes.Equal(ra[x1*4-4:x1*4], rb[x1*4-4:x1*4]) {
+			x1--
+		}
+
+		r.Min.X = min(r.Min.X, x0)
+		r.Max.X = max(r.Max.X, x1)
+		r.Min.Y = min(r.Min.Y, y)
+		r.Max.Y = y + 1
+	}
+
+	if r.Max.Y == 0 {
+		return image.Rectangle{}
+	}
+
+	r.Min.X &^= 1
+	r.Min.Y &^= 1
+
+	return r
+}
+
+// opaqueChanges reports whether every pixel of cur that differs from prev within rect is opaque,
+// so that blending the frame over the previous canvas reproduces cur exactly.
+func opaqueChanges(prev, cur *image.NRGBA, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := cur.PixOffset(x, y)
+			if cur.Pix[i+3] != 0xff && !bytes.Equal(cur.Pix[i:i+4], prev.Pix[i:i+4]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
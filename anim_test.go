@@ -0,0 +1,405 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"testing"
+
+	"github.com/gen2brain/webp/internal/resample"
+)
+
+// movingFrames returns frames of a gradient with a small square moving over it.
+func movingFrames(w, h, n int) []image.Image {
+	bg := gradientFrame(w, h)
+
+	frames := make([]image.Image, n)
+	for i := range frames {
+		img := image.NewNRGBA(bg.Rect)
+		copy(img.Pix, bg.Pix)
+
+		r := image.Rect(0, 0, 8, 8).Add(image.Pt(3+i*5, 5+i*3))
+		draw.Draw(img, r, image.NewUniform(color.NRGBA{255, 0, uint8(i * 40), 255}), image.Point{}, draw.Src)
+
+		frames[i] = img
+	}
+
+	return frames
+}
+
+func TestEncodeAllOptions(t *testing.T) {
+	anim := &WEBP{
+		Image:      movingFrames(48, 40, 6),
+		Delay:      []int{10, 20, 30, 40, 50, 60},
+		LoopCount:  3,
+		Background: color.NRGBA{10, 20, 30, 40},
+	}
+
+	tests := []Options{
+		{Lossless: true},
+		{Lossless: true, Kmax: 1},
+		{Lossless: true, Kmin: 2, Kmax: 3},
+		{Lossless: true, MinimizeSize: true},
+		{Lossless: true, AllowMixed: true, Quality: 100},
+	}
+
+	for _, opt := range tests {
+		opt.Backend = BackendWasm
+
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, anim, opt); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+		if err != nil {
+			t.Fatalf("%+v: %v", opt, err)
+		}
+
+		if got.LoopCount != anim.LoopCount {
+			t.Errorf("%+v: loop count %d, want %d", opt, got.LoopCount, anim.LoopCount)
+		}
+		if got.Background != anim.Background {
+			t.Errorf("%+v: background %v, want %v", opt, got.Background, anim.Background)
+		}
+
+		if len(got.Image) != len(anim.Image) {
+			t.Fatalf("%+v: got %d frames, want %d", opt, len(got.Image), len(anim.Image))
+		}
+
+		for i := range anim.Image {
+			if got.Delay[i] != anim.Delay[i] {
+				t.Errorf("%+v: frame %d delay %d, want %d", opt, i, got.Delay[i], anim.Delay[i])
+			}
+
+			if opt.AllowMixed {
+				if d := meanDiff(got.Image[i].(*image.NRGBA), anim.Image[i].(*image.NRGBA)); d > 4 {
+					t.Errorf("%+v: frame %d differs by %.2f on average", opt, i, d)
+				}
+			} else if d := maxDiff(got.Image[i].(*image.NRGBA), anim.Image[i].(*image.NRGBA)); d != 0 {
+				t.Errorf("%+v: frame %d differs by %d", opt, i, d)
+			}
+		}
+	}
+}
+
+func TestEncodeAllKeyframes(t *testing.T) {
+	anim := &WEBP{Image: movingFrames(48, 40, 7), Delay: make([]int, 7)}
+
+	subframes := func(opt Options) int {
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, anim, opt); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := parseContainer(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for _, f := range c.frames {
+			if f.width != c.width || f.height != c.height {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	if n := subframes(Options{Lossless: true, Kmax: 1, Backend: BackendWasm}); n != 0 {
+		t.Errorf("Kmax 1: got %d sub-frames, want 0", n)
+	}
+	if n := subframes(Options{Lossless: true, MinimizeSize: true, Backend: BackendWasm}); n != 6 {
+		t.Errorf("MinimizeSize: got %d sub-frames, want 6", n)
+	}
+}
+
+func TestEncodeAllIdenticalFrames(t *testing.T) {
+	frame := gradientFrame(16, 16)
+	anim := &WEBP{Image: []image.Image{frame, frame, frame}, Delay: []int{10, 20, 30}}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, Options{Lossless: true, MinimizeSize: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Image) != 1 || got.Delay[0] != 60 {
+		t.Errorf("got %d frames with delays %v, want 1 frame of 60", len(got.Image), got.Delay)
+	}
+
+	// The merged duration stops at the 24-bit limit instead of wrapping.
+	anim.Delay = []int{1 << 23, 1 << 23, 1 << 23}
+
+	buf.Reset()
+	if err := EncodeAll(&buf, anim, Options{Lossless: true, MinimizeSize: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Frames) != 1 || info.Frames[0].Duration != maxFrameDuration {
+		t.Errorf("got frames %+v, want 1 frame of %d", info.Frames, maxFrameDuration)
+	}
+}
+
+func TestEncodeAllLoopCount(t *testing.T) {
+	anim := &WEBP{Image: movingFrames(32, 32, 2), LoopCount: 70000}
+
+	opts := []Options{{Backend: BackendWasm}, {Kmax: 1, Backend: BackendWasm}}
+	if Dynamic() == nil && dynamicMux {
+		opts = append(opts, Options{Backend: BackendDynamic})
+	}
+
+	for _, opt := range opts {
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, anim, opt); err != nil {
+			t.Fatalf("%v kmax %d: %v", opt.Backend, opt.Kmax, err)
+		}
+
+		info, err := Inspect(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.LoopCount != 0xffff {
+			t.Errorf("%v kmax %d: got loop count %d, want 65535", opt.Backend, opt.Kmax, info.LoopCount)
+		}
+	}
+}
+
+func TestEncodeAllOptionsDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	anim := &WEBP{
+		Image:      movingFrames(48, 40, 4),
+		Delay:      []int{10, 20, 30, 40},
+		LoopCount:  5,
+		Background: color.NRGBA{1, 2, 3, 4},
+	}
+
+	for _, opt := range []Options{{Lossless: true, Kmin: 1, Kmax: 2}, {Lossless: true, MinimizeSize: true, AllowMixed: true}} {
+		opt.Backend = BackendDynamic
+
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, anim, opt); err != nil {
+			if err == dynamicMuxErr {
+				fmt.Println(err)
+				t.Skip()
+			}
+			t.Fatal(err)
+		}
+
+		for _, backend := range []Backend{BackendDynamic, BackendWasm} {
+			got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.LoopCount != anim.LoopCount || got.Background != anim.Background {
+				t.Errorf("%v: got loop %d background %v", backend, got.LoopCount, got.Background)
+			}
+
+			for i := range anim.Image {
+				if d := meanDiff(got.Image[i].(*image.NRGBA), anim.Image[i].(*image.NRGBA)); d > 4 {
+					t.Errorf("%+v %v: frame %d differs by %.2f on average", opt, backend, i, d)
+				}
+			}
+		}
+	}
+}
+
+// meanDiff is the mean absolute difference per channel of a and b.
+func meanDiff(a, b *image.NRGBA) float64 {
+	sum := 0
+	for i := range a.Pix {
+		v := int(a.Pix[i]) - int(b.Pix[i])
+		if v < 0 {
+			v = -v
+		}
+		sum += v
+	}
+	return float64(sum) / float64(len(a.Pix))
+}
+
+func TestEncodeAllFrameOptions(t *testing.T) {
+	yes, no := true, false
+
+	frames := movingFrames(48, 40, 3)
+	anim := &WEBP{
+		Image:   frames,
+		Delay:   []int{100, 100, 100},
+		Options: []*FrameOptions{{Quality: 50, Lossless: &no}, nil, {Exact: &yes}},
+	}
+
+	backends := []Backend{BackendWasm}
+	if Dynamic() == nil && dynamicMux {
+		backends = append(backends, BackendDynamic)
+	}
+
+	for _, backend := range backends {
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, anim, Options{Lossless: true, Backend: backend}); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := parseContainer(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"VP8 ", "VP8L", "VP8L"}
+		for i, f := range c.frames {
+			fourcc := f.chunks[len(f.chunks)-1].fourcc
+			if fourcc != want[i] {
+				t.Errorf("%v: frame %d is %q, want %q", backend, i, fourcc, want[i])
+			}
+		}
+	}
+}
+
+func TestEncodeAllCanvas(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(4, 6, 14, 12))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	blue := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	draw.Draw(blue, blue.Rect, image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		anim   *WEBP
+		canvas image.Rectangle
+		rects  []image.Rectangle
+	}{
+		{
+			anim:   &WEBP{Image: []image.Image{red, blue}, Config: image.Config{Width: 20, Height: 16}},
+			canvas: image.Rect(0, 0, 20, 16),
+			rects:  []image.Rectangle{red.Rect, blue.Rect},
+		},
+		{
+			anim:   &WEBP{Image: []image.Image{red, blue}, Offset: []image.Point{{0, 0}, {10, 4}}},
+			canvas: image.Rect(0, 0, 16, 10),
+			rects:  []image.Rectangle{image.Rect(0, 0, 10, 6), image.Rect(10, 4, 16, 10)},
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := EncodeAll(&buf, tt.anim, Options{Lossless: true, Backend: BackendWasm}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b := got.Image[0].Bounds(); b != tt.canvas {
+			t.Fatalf("got canvas %v, want %v", b, tt.canvas)
+		}
+
+		for i, img := range got.Image {
+			src := tt.anim.Image[i].(*image.NRGBA)
+			want := src.Pix[:4]
+
+			for y := 0; y < tt.canvas.Dy(); y++ {
+				for x := 0; x < tt.canvas.Dx(); x++ {
+					c := img.(*image.NRGBA).NRGBAAt(x, y)
+					if image.Pt(x, y).In(tt.rects[i]) {
+						if c != (color.NRGBA{want[0], want[1], want[2], want[3]}) {
+							t.Fatalf("frame %d: pixel %d,%d is %v inside the frame", i, x, y, c)
+						}
+					} else if i == 0 && c.A != 0 {
+						t.Fatalf("frame %d: pixel %d,%d is %v outside the frame", i, x, y, c)
+					} else if prev := got.Image[max(i-1, 0)].(*image.NRGBA).NRGBAAt(x, y); i > 0 && c != prev {
+						t.Fatalf("frame %d: pixel %d,%d is %v, want %v of the previous frame", i, x, y, c, prev)
+					}
+				}
+			}
+		}
+	}
+
+	// A translucent frame blends with the frame below it.
+	glass := image.NewNRGBA(image.Rect(4, 6, 8, 8))
+	draw.Draw(glass, glass.Rect, image.NewUniform(color.NRGBA{0, 0, 255, 128}), image.Point{}, draw.Src)
+
+	anim := &WEBP{Image: []image.Image{red, glass}, Config: image.Config{Width: 20, Height: 16}}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, Options{Lossless: true, Exact: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := got.Image[1].(*image.NRGBA).NRGBAAt(5, 7); c.A != 255 || c.R < 100 || c.B < 100 {
+		t.Errorf("blended pixel is %v, want opaque purple", c)
+	}
+
+	anim = &WEBP{Image: []image.Image{red}, Config: image.Config{Width: 8, Height: 8}}
+	if err := EncodeAll(io.Discard, anim, Options{Backend: BackendWasm}); !errors.Is(err, ErrEncode) {
+		t.Errorf("frame outside canvas: got %v, want ErrEncode", err)
+	}
+}
+
+func TestEncodeAllResized(t *testing.T) {
+	anim, err := DecodeAll(bytes.NewReader(testWebpAnim), Options{Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scaling every decoded frame gives a canvas of the new size.
+	b := anim.Image[0].Bounds()
+	for i, img := range anim.Image {
+		anim.Image[i] = resample.Resize(img, b.Dx()/2, b.Dy()/2)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, Options{Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != b.Dx()/2 || cfg.Height != b.Dy()/2 {
+		t.Errorf("got canvas %dx%d, want %dx%d", cfg.Width, cfg.Height, b.Dx()/2, b.Dy()/2)
+	}
+}
+
+func TestFrameOptionsApply(t *testing.T) {
+	base := frameConfig{quality: 80, method: 5, lossless: true, exact: true}
+	fast, off := 0, false
+
+	tests := []struct {
+		opt  FrameOptions
+		want frameConfig
+	}{
+		{FrameOptions{}, base},
+		{FrameOptions{Quality: 30}, frameConfig{quality: 30, method: 5, lossless: true, exact: true}},
+		{FrameOptions{Quality: 300}, frameConfig{quality: 100, method: 5, lossless: true, exact: true}},
+		{FrameOptions{Method: &fast}, frameConfig{quality: 80, method: 0, lossless: true, exact: true}},
+		{FrameOptions{Lossless: &off, Exact: &off}, frameConfig{quality: 80, method: 5}},
+	}
+
+	for i, tt := range tests {
+		if got := tt.opt.apply(base); got != tt.want {
+			t.Errorf("%d: got %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
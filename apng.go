@@ -0,0 +1,523 @@
+package webp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// ErrAPNG is returned for malformed animated PNG files.
+var ErrAPNG = errors.New("webp: invalid APNG")
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose and blend operations (fcTL).
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+// apngFrame is a frame of an APNG file: its image, position on the canvas and fcTL parameters.
+type apngFrame struct {
+	img     *image.NRGBA
+	x, y    int
+	delay   int // milliseconds
+	dispose byte
+	blend   byte
+}
+
+// apngMaxSize is the largest canvas DecodeAPNG accepts, that of a WEBP image.
+const apngMaxSize = 1 << 14
+
+// DecodeAPNG reads an animated PNG and returns its frames composited on the canvas, with the
+// fcTL blend and dispose operations applied, ready for EncodeAll. A PNG without an acTL chunk
+// is returned as a single frame.
+//
+// Every frame is returned as a full canvas, so the result takes frames*width*height*4 bytes.
+// Canvases larger than a WEBP can hold (16384x16384) are rejected, and the Limits of opts
+// are checked against the IHDR and the frame count before any pixels are allocated; the
+// other Options are ignored.
+func DecodeAPNG(r io.Reader, opts ...Options) (*WEBP, error) {
+	var limits Limits
+	if len(opts) > 0 {
+		limits = opts[0].Limits
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(pngHeader) || string(data[:len(pngHeader)]) != pngHeader {
+		return nil, fmt.Errorf("%w: not a PNG file", ErrAPNG)
+	}
+
+	var (
+		ihdr     []byte
+		shared   []byte // PLTE and tRNS chunks, needed to decode every frame
+		frames   []apngFrame
+		fctl     []byte // the pending fcTL, nil while reading the default image
+		idat     []byte
+		loop     int
+		animated bool
+		width    int
+		height   int
+	)
+
+	// flush decodes the image data collected for the pending fcTL.
+	flush := func() error {
+		if fctl == nil || idat == nil {
+			idat = nil
+			return nil
+		}
+
+		f, err := parseFCTL(fctl, width, height)
+		if err != nil {
+			return err
+		}
+
+		hdr := bytes.Clone(ihdr)
+		binary.BigEndian.PutUint32(hdr[0:], uint32(f.img.Rect.Dx()))
+		binary.BigEndian.PutUint32(hdr[4:], uint32(f.img.Rect.Dy()))
+
+		var buf bytes.Buffer
+		buf.WriteString(pngHeader)
+		writePNGChunk(&buf, "IHDR", hdr)
+		buf.Write(shared)
+		writePNGChunk(&buf, "IDAT", idat)
+		writePNGChunk(&buf, "IEND", nil)
+
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return fmt.Errorf("%w: frame %d: %w", ErrAPNG, len(frames), err)
+		}
+
+		f.img = imageToNRGBA(img)
+		frames = append(frames, f)
+		fctl, idat = nil, nil
+
+		return nil
+	}
+
+	for off := len(pngHeader); off < len(data); {
+		if off+12 > len(data) {
+			return nil, fmt.Errorf("%w: truncated chunk at %d", ErrAPNG, off)
+		}
+
+		size := int(binary.BigEndian.Uint32(data[off:]))
+		typ := string(data[off+4 : off+8])
+		if size < 0 || size > len(data)-off-12 {
+			return nil, fmt.Errorf("%w: chunk %q at %d exceeds data", ErrAPNG, typ, off)
+		}
+
+		body := data[off+8 : off+8+size]
+		off += 12 + size
+
+		switch typ {
+		case "IHDR":
+			if len(body) != 13 {
+				return nil, fmt.Errorf("%w: IHDR size %d", ErrAPNG, len(body))
+			}
+
+			ihdr = body
+			width = int(binary.BigEndian.Uint32(body[0:]))
+			height = int(binary.BigEndian.Uint32(body[4:]))
+
+			if width <= 0 || height <= 0 || width > apngMaxSize || height > apngMaxSize {
+				return nil, fmt.Errorf("%w: canvas %dx%d", ErrAPNG, width, height)
+			}
+
+			if err := limits.checkSize(width, height); err != nil {
+				return nil, err
+			}
+		case "PLTE", "tRNS":
+			shared = appendPNGChunk(shared, typ, body)
+		case "acTL":
+			if len(body) != 8 {
+				return nil, fmt.Errorf("%w: acTL size %d", ErrAPNG, len(body))
+			}
+
+			animated = true
+			loop = int(binary.BigEndian.Uint32(body[4:]))
+		case "fcTL":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+
+			if ihdr == nil {
+				return nil, fmt.Errorf("%w: fcTL before IHDR", ErrAPNG)
+			}
+
+			if err := checkAPNGFrames(limits, len(frames)+1, width, height); err != nil {
+				return nil, err
+			}
+
+			fctl = body
+		case "IDAT":
+			if ihdr == nil {
+				return nil, fmt.Errorf("%w: IDAT before IHDR", ErrAPNG)
+			}
+
+			if !animated && fctl == nil {
+				if err := checkAPNGFrames(limits, 1, width, height); err != nil {
+					return nil, err
+				}
+
+				fctl = stillFCTL(width, height)
+			}
+
+			// IDAT before any fcTL is a default image outside the animation; it is dropped by flush.
+			idat = append(idat, body...)
+		case "fdAT":
+			if len(body) < 4 {
+				return nil, fmt.Errorf("%w: fdAT too short", ErrAPNG)
+			}
+
+			idat = append(idat, body[4:]...)
+		case "IEND":
+			off = len(data)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if ihdr == nil || len(frames) == 0 {
+		return nil, fmt.Errorf("%w: no frames", ErrAPNG)
+	}
+
+	ret := &WEBP{
+		Image:     make([]image.Image, 0, len(frames)),
+		Delay:     make([]int, 0, len(frames)),
+		LoopCount: min(loop, 0xffff),
+		Config:    image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height},
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for i, f := range frames {
+		rect := f.img.Rect.Add(image.Pt(f.x, f.y))
+
+		dispose := f.dispose
+		if i == 0 && dispose == apngDisposePrevious {
+			dispose = apngDisposeBackground
+		}
+
+		var previous *image.NRGBA
+		if dispose == apngDisposePrevious {
+			previous = image.NewNRGBA(rect)
+			draw.Draw(previous, rect, canvas, rect.Min, draw.Src)
+		}
+
+		if f.blend == apngBlendOver {
+			draw.Draw(canvas, rect, f.img, f.img.Rect.Min, draw.Over)
+		} else {
+			draw.Draw(canvas, rect, f.img, f.img.Rect.Min, draw.Src)
+		}
+
+		frame := image.NewNRGBA(canvas.Rect)
+		copy(frame.Pix, canvas.Pix)
+
+		ret.Image = append(ret.Image, frame)
+		ret.Delay = append(ret.Delay, f.delay)
+
+		switch dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			draw.Draw(canvas, rect, previous, rect.Min, draw.Src)
+		}
+	}
+
+	return ret, nil
+}
+
+// checkAPNGFrames validates frames canvases of width x height against the frame count and
+// decoded size limits.
+func checkAPNGFrames(l Limits, frames, width, height int) error {
+	if l.MaxFrames > 0 && frames > l.MaxFrames {
+		return fmt.Errorf("%w: %d frames > %d", ErrLimit, frames, l.MaxFrames)
+	}
+
+	return l.checkBytes(frames * width * height * 4)
+}
+
+// EncodeAPNG writes the decoded animation anim, e.g. as returned by DecodeAll, to w as an
+// animated PNG. Each frame after the first stores only the rectangle that changed, and the
+// pixels are stored exactly. A lossless WEBP decoded with ModeNRGBA therefore survives the
+// round trip pixel for pixel on the dynamic backend; on wasm, translucent pixels carry the
+// error of its ModeNRGBA, see there.
+func EncodeAPNG(w io.Writer, anim *WEBP) error {
+	if anim == nil || len(anim.Image) == 0 {
+		return ErrEncode
+	}
+
+	frames := make([]apngFrame, 0, len(anim.Image))
+
+	b := anim.Image[0].Bounds()
+	canvas := image.Rect(0, 0, b.Dx(), b.Dy())
+
+	var prev *image.NRGBA
+	for i, img := range anim.Image {
+		b := img.Bounds()
+		cur := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(cur, cur.Rect, img, b.Min, draw.Src)
+
+		if cur.Rect != canvas {
+			return fmt.Errorf("%w: frame %d size differs from the first frame", ErrEncode, i)
+		}
+
+		f := apngFrame{img: cur}
+		if i < len(anim.Delay) {
+			f.delay = anim.Delay[i]
+		}
+
+		if prev != nil {
+			rect := diffRect(prev, cur)
+			if rect.Empty() {
+				// Keep the frame, and its timing, as an unchanged pixel.
+				rect = image.Rect(0, 0, 1, 1)
+			}
+
+			f.img = cur.SubImage(rect).(*image.NRGBA)
+			f.x, f.y = rect.Min.X, rect.Min.Y
+		}
+
+		frames = append(frames, f)
+		prev = cur
+	}
+
+	return writeAPNG(w, frames, anim.LoopCount)
+}
+
+// writeAPNG writes frames as an APNG; the first frame is also the default image and must cover the canvas.
+func writeAPNG(w io.Writer, frames []apngFrame, loopCount int) error {
+	width, height := frames[0].img.Rect.Dx(), frames[0].img.Rect.Dy()
+
+	// All frames share the IHDR color type: RGB when every frame is opaque, RGBA otherwise.
+	opaque := true
+	for _, f := range frames {
+		opaque = opaque && f.img.Opaque()
+	}
+
+	colorType := byte(6)
+	if opaque {
+		colorType = 2
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(pngHeader)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8
+	ihdr[9] = colorType
+	writePNGChunk(bw, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(loopCount))
+	writePNGChunk(bw, "acTL", actl)
+
+	seq := uint32(0)
+
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(f.img.Rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(f.img.Rect.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(f.x))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(f.y))
+
+		// Milliseconds fit the 16-bit numerator up to a minute; beyond that use 1/100s.
+		if f.delay <= 0xffff {
+			binary.BigEndian.PutUint16(fctl[20:], uint16(f.delay))
+			binary.BigEndian.PutUint16(fctl[22:], 1000)
+		} else {
+			binary.BigEndian.PutUint16(fctl[20:], uint16(min(f.delay/10, 0xffff)))
+			binary.BigEndian.PutUint16(fctl[22:], 100)
+		}
+
+		fctl[24] = f.dispose
+		fctl[25] = f.blend
+		writePNGChunk(bw, "fcTL", fctl)
+		seq++
+
+		data, err := compressRows(f.img, colorType)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			writePNGChunk(bw, "IDAT", data)
+		} else {
+			writePNGChunk(bw, "fdAT", append(binary.BigEndian.AppendUint32(nil, seq), data...))
+			seq++
+		}
+	}
+
+	writePNGChunk(bw, "IEND", nil)
+
+	return bw.Flush()
+}
+
+// compressRows returns the zlib-compressed, filtered scanlines of img for colorType 2 (RGB) or 6 (RGBA).
+// Each row uses the filter with the smallest sum of absolute values, the usual PNG heuristic.
+func compressRows(img *image.NRGBA, colorType byte) ([]byte, error) {
+	bpp := 4
+	if colorType == 2 {
+		bpp = 3
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := w * bpp
+
+	prev := make([]byte, n)
+	row := make([]byte, n)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, n+1)
+		filtered[i][0] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+
+	for y := 0; y < h; y++ {
+		px := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			copy(row[x*bpp:x*bpp+bpp], px[x*4:x*4+bpp])
+		}
+
+		for i := 0; i < n; i++ {
+			var a, c byte
+			if i >= bpp {
+				a, c = row[i-bpp], prev[i-bpp]
+			}
+			b := prev[i]
+
+			filtered[0][i+1] = row[i]
+			filtered[1][i+1] = row[i] - a
+			filtered[2][i+1] = row[i] - b
+			filtered[3][i+1] = row[i] - byte((int(a)+int(b))/2)
+			filtered[4][i+1] = row[i] - paeth(a, b, c)
+		}
+
+		best, bestSum := 0, -1
+		for f, line := range filtered {
+			sum := 0
+			for _, v := range line[1:] {
+				sum += abs(int(int8(v)))
+			}
+
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = f, sum
+			}
+		}
+
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+
+		prev, row = row, prev
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+// parseFCTL parses an fcTL chunk into a frame with an empty image of the frame size.
+func parseFCTL(b []byte, width, height int) (apngFrame, error) {
+	var f apngFrame
+
+	if len(b) != 26 {
+		return f, fmt.Errorf("%w: fcTL size %d", ErrAPNG, len(b))
+	}
+
+	w := int(binary.BigEndian.Uint32(b[4:]))
+	h := int(binary.BigEndian.Uint32(b[8:]))
+	f.x = int(binary.BigEndian.Uint32(b[12:]))
+	f.y = int(binary.BigEndian.Uint32(b[16:]))
+
+	if w <= 0 || h <= 0 || f.x < 0 || f.y < 0 || f.x+w > width || f.y+h > height {
+		return f, fmt.Errorf("%w: frame at %d,%d (%dx%d) exceeds canvas %dx%d", ErrAPNG, f.x, f.y, w, h, width, height)
+	}
+
+	num := int(binary.BigEndian.Uint16(b[20:]))
+	den := int(binary.BigEndian.Uint16(b[22:]))
+	if den == 0 {
+		den = 100
+	}
+
+	f.delay = (num*1000 + den/2) / den
+	f.dispose = b[24]
+	f.blend = b[25]
+	f.img = image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	return f, nil
+}
+
+// stillFCTL is the fcTL equivalent of a plain PNG: one frame covering the canvas.
+func stillFCTL(width, height int) []byte {
+	b := make([]byte, 26)
+	binary.BigEndian.PutUint32(b[4:], uint32(width))
+	binary.BigEndian.PutUint32(b[8:], uint32(height))
+
+	return b
+}
+
+func appendPNGChunk(dst []byte, typ string, body []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(body)))
+	dst = append(dst, typ...)
+	dst = append(dst, body...)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(body)
+
+	return binary.BigEndian.AppendUint32(dst, crc.Sum32())
+}
+
+func writePNGChunk(w io.Writer, typ string, body []byte) {
+	w.Write(appendPNGChunk(nil, typ, body))
+}
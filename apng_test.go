@@ -0,0 +1,195 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func TestAPNGRoundTrip(t *testing.T) {
+	// The wasm animation decoder composites premultiplied, so only opaque and fully
+	// transparent pixels are exact there; the shared library decodes unpremultiplied.
+	testAPNGRoundTrip(t, BackendWasm, color.NRGBA{})
+
+	if !dynamicMux {
+		fmt.Println(dynamicMuxErr)
+		return
+	}
+
+	testAPNGRoundTrip(t, BackendDynamic, color.NRGBA{200, 100, 50, 60})
+}
+
+func testAPNGRoundTrip(t *testing.T, backend Backend, c color.NRGBA) {
+	frames := movingFrames(40, 30, 4)
+	for _, img := range frames[1:] {
+		img.(*image.NRGBA).SetNRGBA(1, 1, c)
+	}
+	frames = append(frames, frames[3])
+
+	src := &WEBP{Image: frames, Delay: []int{100, 40, 70, 1000, 20}, LoopCount: 4}
+
+	var webp bytes.Buffer
+	if err := EncodeAll(&webp, src, Options{Lossless: true, Exact: true, Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := DecodeAll(bytes.NewReader(webp.Bytes()), Options{ColorMode: ModeNRGBA, Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var apng bytes.Buffer
+	if err := EncodeAPNG(&apng, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default image is the first frame for viewers without APNG support.
+	first, err := png.Decode(bytes.NewReader(apng.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := maxDiff(imageToNRGBA(first), anim.Image[0].(*image.NRGBA)); d != 0 {
+		t.Errorf("%v: default image differs by %d", backend, d)
+	}
+
+	got, err := DecodeAPNG(bytes.NewReader(apng.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.LoopCount != src.LoopCount {
+		t.Errorf("%v: got loop count %d, want %d", backend, got.LoopCount, src.LoopCount)
+	}
+	// The encoder merged the identical last frame into the previous one.
+	if len(got.Image) != len(frames)-1 || !slices.Equal(got.Delay, []int{100, 40, 70, 1020}) {
+		t.Fatalf("%v: got %d frames with delays %v", backend, len(got.Image), got.Delay)
+	}
+
+	for i := range got.Image {
+		if d := maxDiff(got.Image[i].(*image.NRGBA), frames[i].(*image.NRGBA)); d != 0 {
+			t.Errorf("%v: frame %d differs by %d", backend, i, d)
+		}
+	}
+
+	// And back to WEBP.
+	webp.Reset()
+	if err := EncodeAll(&webp, got, Options{Lossless: true, Exact: true, Backend: backend}); err != nil {
+		t.Fatal(err)
+	}
+
+	back, err := DecodeAll(bytes.NewReader(webp.Bytes()), Options{ColorMode: ModeNRGBA, Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(back.Image) != len(got.Image) {
+		t.Fatalf("%v: got %d webp frames, want %d", backend, len(back.Image), len(got.Image))
+	}
+
+	for i := range back.Image {
+		if d := maxDiff(back.Image[i].(*image.NRGBA), frames[i].(*image.NRGBA)); d != 0 {
+			t.Errorf("%v: webp frame %d differs by %d", backend, i, d)
+		}
+	}
+}
+
+func TestDecodeAPNGDisposeBlend(t *testing.T) {
+	solid := func(w, h int, c color.NRGBA) *image.NRGBA {
+		return solidFrame(w, h, c).(*image.NRGBA)
+	}
+
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	half := color.NRGBA{0, 0, 255, 128}
+
+	frames := []apngFrame{
+		{img: solid(8, 8, red), delay: 10},
+		{img: solid(4, 4, green), x: 2, y: 2, delay: 20, dispose: apngDisposePrevious},
+		{img: solid(4, 4, half), x: 4, y: 4, delay: 30, dispose: apngDisposeBackground, blend: apngBlendOver},
+		{img: solid(2, 2, green), delay: 40},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAPNG(&buf, frames, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAPNG(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	over := solid(1, 1, red)
+	draw.Draw(over, over.Rect, image.NewUniform(half), image.Point{}, draw.Over)
+
+	tests := []struct {
+		frame int
+		pt    image.Point
+		want  color.NRGBA
+	}{
+		{0, image.Pt(3, 3), red},
+		{1, image.Pt(3, 3), green},
+		{1, image.Pt(6, 6), red},
+		{2, image.Pt(3, 3), red}, // frame 1 disposed to previous
+		{2, image.Pt(5, 5), over.NRGBAAt(0, 0)},
+		{3, image.Pt(5, 5), color.NRGBA{}}, // frame 2 disposed to background
+		{3, image.Pt(1, 1), green},
+		{3, image.Pt(3, 3), red},
+	}
+
+	for _, tt := range tests {
+		if c := got.Image[tt.frame].(*image.NRGBA).NRGBAAt(tt.pt.X, tt.pt.Y); c != tt.want {
+			t.Errorf("frame %d at %v: got %v, want %v", tt.frame, tt.pt, c, tt.want)
+		}
+	}
+
+	if want := []int{10, 20, 30, 40}; !slices.Equal(got.Delay, want) {
+		t.Errorf("got delays %v, want %v", got.Delay, want)
+	}
+}
+
+func TestDecodeAPNGLimits(t *testing.T) {
+	// An IHDR declaring a 40000x40000 canvas with a single 1x1 frame.
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 40000)
+	binary.BigEndian.PutUint32(ihdr[4:], 40000)
+	ihdr[8], ihdr[9] = 8, 6
+
+	data := []byte(pngHeader)
+	data = appendPNGChunk(data, "IHDR", ihdr)
+	data = appendPNGChunk(data, "acTL", []byte{0, 0, 0, 1, 0, 0, 0, 0})
+	data = appendPNGChunk(data, "fcTL", make([]byte, 26))
+	data = appendPNGChunk(data, "IEND", nil)
+
+	if _, err := DecodeAPNG(bytes.NewReader(data)); !errors.Is(err, ErrAPNG) {
+		t.Errorf("huge canvas: got %v, want ErrAPNG", err)
+	}
+
+	frames := []apngFrame{
+		{img: solidFrame(8, 8, color.NRGBA{255, 0, 0, 255}).(*image.NRGBA)},
+		{img: solidFrame(4, 4, color.NRGBA{0, 255, 0, 255}).(*image.NRGBA)},
+		{img: solidFrame(4, 4, color.NRGBA{0, 0, 255, 255}).(*image.NRGBA)},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAPNG(&buf, frames, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range []Limits{{MaxWidth: 7}, {MaxPixels: 63}, {MaxFrames: 2}, {MaxBytes: 3*8*8*4 - 1}} {
+		if _, err := DecodeAPNG(bytes.NewReader(buf.Bytes()), Options{Limits: l}); !errors.Is(err, ErrLimit) {
+			t.Errorf("%+v: got %v, want ErrLimit", l, err)
+		}
+	}
+
+	if _, err := DecodeAPNG(bytes.NewReader(buf.Bytes()), Options{Limits: Limits{MaxFrames: 3, MaxBytes: 3 * 8 * 8 * 4}}); err != nil {
+		t.Error(err)
+	}
+}
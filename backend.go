@@ -0,0 +1,125 @@
+package webp
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Backend selects the libwebp implementation that serves a call.
+type Backend int
+
+const (
+	// BackendAuto uses the process default set by SetBackend: the dynamic/shared library when it was loaded, the transpiled Go otherwise.
+	BackendAuto Backend = iota
+	// BackendDynamic uses the system libwebp via purego.
+	BackendDynamic
+	// BackendWasm uses libwebp compiled to WASM and transpiled to Go.
+	BackendWasm
+)
+
+// libwebpVersion is the libwebp release transpiled into libwebp.go, keep in sync with LIBWEBP_VERSION in lib/Makefile.
+const libwebpVersion = 0x010600
+
+// String returns the name of the backend.
+func (b Backend) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendDynamic:
+		return "dynamic"
+	case BackendWasm:
+		return "wasm"
+	}
+
+	return "unknown"
+}
+
+var defaultBackend atomic.Int32
+
+// SetBackend sets the process-wide backend used by calls whose Options leave Backend as BackendAuto.
+// It returns the error from opening the shared library when BackendDynamic is requested but unavailable.
+func SetBackend(b Backend) error {
+	switch b {
+	case BackendAuto, BackendWasm:
+	case BackendDynamic:
+		if !dynamic {
+			return dynamicErr
+		}
+	default:
+		return fmt.Errorf("webp: unknown backend %d", b)
+	}
+
+	defaultBackend.Store(int32(b))
+
+	return nil
+}
+
+// ResolveBackend reports the backend that serves a call made with the given options.
+func ResolveBackend(opts ...Options) (Backend, error) {
+	b := BackendAuto
+	if len(opts) > 0 {
+		b = opts[0].Backend
+	}
+
+	return resolveBackend(b)
+}
+
+// resolveBackend maps b to BackendDynamic or BackendWasm.
+func resolveBackend(b Backend) (Backend, error) {
+	if b == BackendAuto {
+		b = Backend(defaultBackend.Load())
+	}
+
+	switch b {
+	case BackendAuto:
+		if dynamic {
+			return BackendDynamic, nil
+		}
+
+		return BackendWasm, nil
+	case BackendDynamic:
+		if !dynamic {
+			return b, dynamicErr
+		}
+
+		return b, nil
+	case BackendWasm:
+		return b, nil
+	}
+
+	return b, fmt.Errorf("webp: unknown backend %d", b)
+}
+
+// resolveMuxBackend is resolveBackend for EncodeAll, which on the dynamic backend also needs libwebpmux.
+// BackendAuto falls back to wasm when only libwebp and libwebpdemux could be loaded.
+func resolveMuxBackend(b Backend) (Backend, error) {
+	r, err := resolveBackend(b)
+	if err != nil || r == BackendWasm || dynamicMux {
+		return r, err
+	}
+
+	if b == BackendAuto && Backend(defaultBackend.Load()) == BackendAuto {
+		return BackendWasm, nil
+	}
+
+	return r, dynamicMuxErr
+}
+
+// Version returns the libwebp decoder and encoder versions ("major.minor.revision") of the given backend.
+func Version(b Backend) (decoder, encoder string, err error) {
+	b, err = resolveBackend(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	dec, enc := libwebpVersion, libwebpVersion
+	if b == BackendDynamic {
+		dec, enc = versionDynamic()
+	}
+
+	return formatVersion(dec), formatVersion(enc), nil
+}
+
+func formatVersion(v int) string {
+	return fmt.Sprintf("%d.%d.%d", (v>>16)&0xff, (v>>8)&0xff, v&0xff)
+}
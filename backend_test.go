@@ -0,0 +1,108 @@
+package webp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolveBackend(t *testing.T) {
+	b, err := ResolveBackend(Options{Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != BackendWasm {
+		t.Errorf("got %v, want %v", b, BackendWasm)
+	}
+
+	b, err = ResolveBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[bool]Backend{true: BackendDynamic, false: BackendWasm}[Dynamic() == nil]; b != want {
+		t.Errorf("got %v, want %v", b, want)
+	}
+
+	if Dynamic() != nil {
+		if _, err := ResolveBackend(Options{Backend: BackendDynamic}); err == nil {
+			t.Error("expected error for unavailable dynamic backend")
+		}
+		if err := SetBackend(BackendDynamic); err == nil {
+			t.Error("expected error for unavailable dynamic backend")
+		}
+	}
+}
+
+func TestSetBackend(t *testing.T) {
+	defer SetBackend(BackendAuto)
+
+	if err := SetBackend(BackendWasm); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ResolveBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != BackendWasm {
+		t.Errorf("got %v, want %v", b, BackendWasm)
+	}
+
+	if _, err := Decode(bytes.NewReader(testWebp)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetBackend(Backend(42)); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	dec, enc, err := Version(BackendWasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec != "1.6.0" || enc != "1.6.0" {
+		t.Errorf("got %s/%s, want 1.6.0", dec, enc)
+	}
+}
+
+func TestVersionDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	dec, enc, err := Version(BackendDynamic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dec, "1.") || !strings.HasPrefix(enc, "1.") {
+		t.Errorf("got %s/%s, want 1.x", dec, enc)
+	}
+}
+
+func TestEncodeBackends(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	img := gradientFrame(64, 48)
+
+	for _, b := range []Backend{BackendDynamic, BackendWasm} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, Options{Lossless: true, Backend: b}); err != nil {
+			t.Fatalf("%v: %v", b, err)
+		}
+
+		dec, err := Decode(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: b})
+		if err != nil {
+			t.Fatalf("%v: %v", b, err)
+		}
+		if dec.Bounds() != img.Bounds() {
+			t.Errorf("%v: bounds %v, want %v", b, dec.Bounds(), img.Bounds())
+		}
+	}
+}
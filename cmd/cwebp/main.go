@@ -0,0 +1,302 @@
+// Command cwebp encodes PNG, JPEG and GIF images to WEBP, like libwebp's cwebp.
+//
+// Usage:
+//
+//	cwebp [options] input -o output.webp
+//
+// It uses the package's Encode, so it works on every platform Go does; the system libwebp
+// is used when present, otherwise the transpiled one.
+//
+// libwebp's presets tune the lossy filter strength, sharpness and spatial noise shaping, which
+// the package does not expose, so -preset is approximated with the settings it has: photo and
+// picture encode as default, while drawing, icon and text, tuned there to keep hard edges,
+// encode losslessly, icon with -exact too. Flags given explicitly override the preset.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gen2brain/webp"
+	"github.com/gen2brain/webp/internal/cli"
+	"github.com/gen2brain/webp/internal/resample"
+)
+
+// preset holds the settings a -preset selects.
+type preset struct {
+	lossless bool
+	exact    bool
+}
+
+// presets maps the cwebp preset names to their approximation, see the package doc.
+var presets = map[string]preset{
+	"default": {},
+	"photo":   {},
+	"picture": {},
+	"drawing": {lossless: true},
+	"icon":    {lossless: true, exact: true},
+	"text":    {lossless: true},
+}
+
+type config struct {
+	output   string
+	quality  float64
+	method   int
+	lossless bool
+	exact    bool
+	preset   string
+	resize   string
+	crop     string
+	metadata string
+	size     int
+	pass     int
+	threads  int
+	backend  string
+	verbose  bool
+	quiet    bool
+	// set holds the names of the flags given explicitly.
+	set map[string]bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "cwebp:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var c config
+
+	fs := flag.NewFlagSet("cwebp", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.output, "o", "", "output file (- for stdout)")
+	fs.Float64Var(&c.quality, "q", webp.DefaultQuality, "quality factor (1:small..100:big)")
+	fs.IntVar(&c.method, "m", webp.DefaultMethod, "compression method (0=fast, 6=slowest)")
+	fs.BoolVar(&c.lossless, "lossless", false, "encode image losslessly")
+	fs.BoolVar(&c.exact, "exact", false, "preserve RGB values in transparent area")
+	fs.StringVar(&c.preset, "preset", "", "preset setting, one of default, photo, picture, drawing, icon, text")
+	fs.StringVar(&c.resize, "resize", "", "resize picture to WxH (0 keeps the aspect ratio)")
+	fs.StringVar(&c.crop, "crop", "", "crop picture to the rectangle x,y,w,h")
+	fs.StringVar(&c.metadata, "metadata", "none", "comma separated list of metadata to copy: all, none, exif, icc, xmp")
+	fs.IntVar(&c.size, "size", 0, "target size in bytes")
+	fs.IntVar(&c.pass, "pass", 6, "number of passes to reach the target size")
+	fs.IntVar(&c.threads, "mt", 0, "number of threads (0 = backend default)")
+	fs.StringVar(&c.backend, "backend", "auto", "libwebp backend: auto, dynamic or wasm")
+	fs.BoolVar(&c.verbose, "v", false, "verbose, print encoding details")
+	fs.BoolVar(&c.quiet, "quiet", false, "do not print anything")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: cwebp [options] input -o output.webp")
+		fs.PrintDefaults()
+	}
+
+	// Options may follow the input file, as with cwebp.
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		if fs.NArg() == 0 {
+			break
+		}
+
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	c.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { c.set[f.Name] = true })
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one input file")
+	}
+
+	if c.output == "" {
+		return errors.New("missing output file (-o)")
+	}
+
+	return convert(inputs[0], c, stdin, stdout, stderr)
+}
+
+func convert(input string, c config, stdin io.Reader, stdout, stderr io.Writer) error {
+	var data []byte
+	var err error
+	if input == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+
+	opt, err := options(c)
+	if err != nil {
+		return err
+	}
+
+	if c.crop != "" {
+		r, err := cli.ParseCrop(c.crop)
+		if err != nil {
+			return err
+		}
+
+		img, err = cli.Crop(img, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.resize != "" {
+		w, h, err := cli.ParseSize(c.resize)
+		if err != nil {
+			return err
+		}
+
+		img = resample.Resize(img, w, h)
+	}
+
+	start := time.Now()
+
+	out, err := encode(img, opt, c)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+
+	if c.metadata != "none" && c.metadata != "" {
+		m, err := readMetadata(data, format, c.metadata)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := webp.SetMetadata(&buf, bytes.NewReader(out), m); err != nil {
+			return err
+		}
+
+		out = buf.Bytes()
+	}
+
+	if c.output == "-" {
+		_, err = stdout.Write(out)
+	} else {
+		err = os.WriteFile(c.output, out, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.verbose && !c.quiet {
+		b := img.Bounds()
+		fmt.Fprintf(stderr, "File:      %s (%s)\n", input, format)
+		fmt.Fprintf(stderr, "Dimension: %d x %d\n", b.Dx(), b.Dy())
+		fmt.Fprintf(stderr, "Output:    %d bytes (%.2f bpp)\n", len(out), float64(len(out)*8)/float64(b.Dx()*b.Dy()))
+		fmt.Fprintf(stderr, "Quality:   %d, method %d, lossless %v\n", opt.Quality, opt.Method, opt.Lossless)
+		fmt.Fprintf(stderr, "Time:      %v\n", elapsed.Round(time.Millisecond))
+	} else if !c.quiet {
+		fmt.Fprintf(stderr, "Saved file %s (%d bytes)\n", c.output, len(out))
+	}
+
+	return nil
+}
+
+// options translates the flags to webp.Options, applying the preset before the explicit flags.
+func options(c config) (webp.Options, error) {
+	quality, err := cli.Quality(c.quality)
+	if err != nil {
+		return webp.Options{}, err
+	}
+	if err := cli.CheckMethod(c.method); err != nil {
+		return webp.Options{}, err
+	}
+
+	backend, err := cli.ParseBackend(c.backend)
+	if err != nil {
+		return webp.Options{}, err
+	}
+
+	var p preset
+	if c.preset != "" {
+		var ok bool
+		if p, ok = presets[c.preset]; !ok {
+			return webp.Options{}, fmt.Errorf("unknown preset %q", c.preset)
+		}
+	}
+
+	if c.set["lossless"] {
+		p.lossless = c.lossless
+	}
+	if c.set["exact"] {
+		p.exact = c.exact
+	}
+
+	return webp.Options{
+		Quality:  quality,
+		Method:   c.method,
+		Lossless: p.lossless,
+		Exact:    p.exact,
+		Threads:  c.threads,
+		Backend:  backend,
+	}, nil
+}
+
+// encode encodes img, bisecting the quality for the largest output not above the target size
+// when one is set; if nothing fits, the lowest quality is used.
+func encode(img image.Image, opt webp.Options, c config) ([]byte, error) {
+	if c.size <= 0 || opt.Lossless {
+		return encodeOnce(img, opt)
+	}
+
+	var fit []byte
+
+	lo, hi := 1, 100
+	for i := 0; i < c.pass && lo <= hi; i++ {
+		opt.Quality = (lo + hi) / 2
+
+		out, err := encodeOnce(img, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(out) <= c.size {
+			fit = out
+			lo = opt.Quality + 1
+		} else {
+			hi = opt.Quality - 1
+		}
+	}
+
+	if fit == nil {
+		opt.Quality = 1
+		return encodeOnce(img, opt)
+	}
+
+	return fit, nil
+}
+
+func encodeOnce(img image.Image, opt webp.Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, opt); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
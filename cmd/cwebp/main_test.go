@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(x ^ y), 255})
+		}
+	}
+	return img
+}
+
+func TestCwebp(t *testing.T) {
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	in := filepath.Join(dir, "in.png")
+	if err := os.WriteFile(in, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out.webp")
+	if err := run([]string{"-quiet", in, "-q", "90", "-crop", "8,8,40,30", "-resize", "20x0", "-backend", "wasm", "-o", out}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := decodeConfig(t, out)
+	if cfg.Width != 20 || cfg.Height != 15 {
+		t.Errorf("got %dx%d, want 20x15", cfg.Width, cfg.Height)
+	}
+
+	if err := run([]string{"-quiet", "-preset", "icon", "-backend", "wasm", in, "-o", out}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := webp.Decode(openFile(t, out), webp.Options{ColorMode: webp.ModeNRGBA, Backend: webp.BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.(*image.NRGBA).Pix, testImage().Pix) {
+		t.Error("icon preset is not lossless")
+	}
+
+	for _, args := range [][]string{{"-q", "0"}, {"-q", "101"}, {"-m", "7"}, {"-preset", "sketch"}} {
+		if err := run(append(args, "-quiet", in, "-o", out), nil, io.Discard, io.Discard); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestCwebpPresets(t *testing.T) {
+	for _, tt := range []struct {
+		preset       string
+		set          map[string]bool
+		losslessFlag bool
+		wantLossless bool
+		wantExact    bool
+	}{
+		{preset: "photo"},
+		{preset: "text", wantLossless: true},
+		{preset: "icon", wantLossless: true, wantExact: true},
+		// Explicit flags override the preset.
+		{preset: "icon", set: map[string]bool{"lossless": true}, wantExact: true},
+		{preset: "photo", set: map[string]bool{"lossless": true}, losslessFlag: true, wantLossless: true},
+	} {
+		c := config{quality: 75, method: 4, backend: "auto", preset: tt.preset, lossless: tt.losslessFlag, set: tt.set}
+
+		opt, err := options(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opt.Lossless != tt.wantLossless || opt.Exact != tt.wantExact || opt.Quality != 75 {
+			t.Errorf("%s %v: got %+v", tt.preset, tt.set, opt)
+		}
+	}
+}
+
+func TestCwebpTargetSize(t *testing.T) {
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	in := filepath.Join(dir, "in.png")
+	if err := os.WriteFile(in, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var full, small bytes.Buffer
+	if err := run([]string{"-quiet", "-q", "100", "-backend", "wasm", "-o", "-", in}, nil, &full, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	target := full.Len() / 2
+	if err := run([]string{"-quiet", "-size", strconv.Itoa(target), "-backend", "wasm", "-o", "-", in}, nil, &small, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if small.Len() > target || small.Len() < target/4 {
+		t.Errorf("got %d bytes for a target of %d", small.Len(), target)
+	}
+}
+
+func TestCwebpMetadata(t *testing.T) {
+	exif := []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	icc := []byte("profile data")
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert APP1 Exif and APP2 ICC segments after SOI.
+	var segs []byte
+	segs = appendSegment(segs, 0xe1, append([]byte("Exif\x00\x00"), exif...))
+	segs = appendSegment(segs, 0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), icc...))
+	data := append(append(buf.Bytes()[:2:2], segs...), buf.Bytes()[2:]...)
+
+	var out bytes.Buffer
+	if err := run([]string{"-quiet", "-metadata", "exif,icc", "-backend", "wasm", "-o", "-", "-"}, bytes.NewReader(data), &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := webp.ReadMetadata(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(m.EXIF, exif) || !bytes.Equal(m.ICCP, icc) || m.XMP != nil {
+		t.Errorf("got %q", m)
+	}
+}
+
+func appendSegment(dst []byte, marker byte, body []byte) []byte {
+	dst = append(dst, 0xff, marker)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(body)+2))
+	return append(dst, body...)
+}
+
+func decodeConfig(t *testing.T, name string) image.Config {
+	cfg, err := webp.DecodeConfig(openFile(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func openFile(t *testing.T, name string) io.Reader {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(data)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gen2brain/webp"
+)
+
+// readMetadata extracts the metadata selected by list (all, exif, icc, xmp) from a JPEG or PNG file.
+func readMetadata(data []byte, format, list string) (webp.Metadata, error) {
+	var m webp.Metadata
+
+	var all webp.Metadata
+	switch format {
+	case "jpeg":
+		all = jpegMetadata(data)
+	case "png":
+		all = pngMetadata(data)
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "all":
+			m = all
+		case "exif":
+			m.EXIF = all.EXIF
+		case "icc":
+			m.ICCP = all.ICCP
+		case "xmp":
+			m.XMP = all.XMP
+		case "none":
+		default:
+			return m, fmt.Errorf("unknown metadata %q", name)
+		}
+	}
+
+	return m, nil
+}
+
+// jpegMetadata reads the APP1 Exif and XMP segments and the (possibly split) APP2 ICC profile.
+func jpegMetadata(data []byte) webp.Metadata {
+	const (
+		exifSig = "Exif\x00\x00"
+		xmpSig  = "http://ns.adobe.com/xap/1.0/\x00"
+		iccSig  = "ICC_PROFILE\x00"
+	)
+
+	var m webp.Metadata
+	icc := make(map[byte][]byte)
+
+	for off := 2; off+4 <= len(data) && data[off] == 0xff; {
+		marker := data[off+1]
+		if marker == 0xda || marker == 0xd9 { // start of scan, end of image
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[off+2:]))
+		if size < 2 || off+2+size > len(data) {
+			break
+		}
+
+		seg := data[off+4 : off+2+size]
+		off += 2 + size
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(seg, []byte(exifSig)):
+			m.EXIF = seg[len(exifSig):]
+		case marker == 0xe1 && bytes.HasPrefix(seg, []byte(xmpSig)):
+			m.XMP = seg[len(xmpSig):]
+		case marker == 0xe2 && bytes.HasPrefix(seg, []byte(iccSig)) && len(seg) > len(iccSig)+2:
+			icc[seg[len(iccSig)]] = seg[len(iccSig)+2:]
+		}
+	}
+
+	// ICC chunks are numbered from 1.
+	for i := byte(1); icc[i] != nil; i++ {
+		m.ICCP = append(m.ICCP, icc[i]...)
+	}
+
+	return m
+}
+
+// pngMetadata reads the eXIf, iCCP and XMP iTXt chunks.
+func pngMetadata(data []byte) webp.Metadata {
+	var m webp.Metadata
+
+	for off := 8; off+12 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[off:]))
+		typ := string(data[off+4 : off+8])
+		if size < 0 || off+12+size > len(data) {
+			break
+		}
+
+		body := data[off+8 : off+8+size]
+		off += 12 + size
+
+		switch typ {
+		case "eXIf":
+			m.EXIF = body
+		case "iCCP":
+			// Profile name, NUL, compression method, zlib data.
+			if i := bytes.IndexByte(body, 0); i >= 0 && i+2 <= len(body) {
+				if zr, err := zlib.NewReader(bytes.NewReader(body[i+2:])); err == nil {
+					m.ICCP, _ = io.ReadAll(zr)
+				}
+			}
+		case "iTXt":
+			// Keyword, NUL, compression flag and method, language tag, NUL, translated keyword, NUL, text.
+			const key = "XML:com.adobe.xmp\x00"
+			if bytes.HasPrefix(body, []byte(key)) && len(body) > len(key)+2 && body[len(key)] == 0 {
+				rest := body[len(key)+2:]
+				for n := 0; n < 2; n++ {
+					if i := bytes.IndexByte(rest, 0); i >= 0 {
+						rest = rest[i+1:]
+					}
+				}
+				m.XMP = rest
+			}
+		case "IEND":
+			return m
+		}
+	}
+
+	return m
+}
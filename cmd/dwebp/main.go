@@ -0,0 +1,246 @@
+// Command dwebp decodes WEBP images to PNG, PPM, PAM, TIFF or raw YUV, like libwebp's dwebp.
+//
+// Usage:
+//
+//	dwebp [options] input.webp -o output
+//
+// With -dump, every frame of an animation is written to a numbered file, e.g. out-0001.png.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gen2brain/webp"
+	"github.com/gen2brain/webp/internal/cli"
+	"github.com/gen2brain/webp/internal/resample"
+)
+
+type config struct {
+	output     string
+	format     string
+	crop       string
+	resize     string
+	flip       bool
+	autoRotate bool
+	dump       bool
+	threads    int
+	backend    string
+	quiet      bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "dwebp:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var c config
+
+	fs := flag.NewFlagSet("dwebp", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.output, "o", "", "output file (- for stdout)")
+	formats := map[string]string{"png": "PNG", "ppm": "PPM (no alpha)", "pam": "PAM (RGBA)", "tiff": "TIFF (RGBA)", "yuv": "raw YUV 4:2:0 planes"}
+	for _, f := range []string{"png", "ppm", "pam", "tiff", "yuv"} {
+		fs.BoolFunc(f, "save the output as "+formats[f], func(string) error { c.format = f; return nil })
+	}
+	fs.StringVar(&c.crop, "crop", "", "crop output to the rectangle x,y,w,h")
+	fs.StringVar(&c.resize, "resize", "", "scale output to WxH (0 keeps the aspect ratio)")
+	fs.StringVar(&c.resize, "scale", "", "alias for -resize")
+	fs.BoolVar(&c.flip, "flip", false, "flip the output vertically")
+	fs.BoolVar(&c.autoRotate, "autorotate", false, "apply the EXIF orientation")
+	fs.BoolVar(&c.dump, "dump", false, "write every animation frame to a numbered file")
+	fs.IntVar(&c.threads, "mt", 0, "number of threads (0 = backend default)")
+	fs.StringVar(&c.backend, "backend", "auto", "libwebp backend: auto, dynamic or wasm")
+	fs.BoolVar(&c.quiet, "quiet", false, "do not print anything")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: dwebp [options] input.webp -o output")
+		fs.PrintDefaults()
+	}
+
+	// Options may follow the input file, as with dwebp.
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		if fs.NArg() == 0 {
+			break
+		}
+
+		inputs = append(inputs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(inputs) != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one input file")
+	}
+
+	if c.output == "" {
+		return errors.New("missing output file (-o)")
+	}
+
+	if c.format == "" {
+		c.format = formatOf(c.output)
+	}
+
+	return convert(inputs[0], c, stdin, stdout, stderr)
+}
+
+func convert(input string, c config, stdin io.Reader, stdout, stderr io.Writer) error {
+	var data []byte
+	var err error
+	if input == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	opt := webp.Options{ColorMode: webp.ModeNRGBA, AutoRotate: c.autoRotate, Threads: c.threads}
+
+	// Untransformed YUV output is the decoder's own YUV, not a conversion back from RGB.
+	if c.format == "yuv" && c.crop == "" && c.resize == "" && !c.flip {
+		opt.ColorMode = webp.ModeYCbCrA
+	}
+
+	opt.Backend, err = cli.ParseBackend(c.backend)
+	if err != nil {
+		return err
+	}
+
+	var frames []image.Image
+	if c.dump {
+		anim, err := webp.DecodeAll(bytes.NewReader(data), opt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+
+		frames = anim.Image
+	} else {
+		img, err := webp.Decode(bytes.NewReader(data), opt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+
+		frames = []image.Image{img}
+	}
+
+	for i, img := range frames {
+		img, err := transform(img, c)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := write(&buf, img, c.format); err != nil {
+			return err
+		}
+
+		name := c.output
+		if c.dump {
+			name = frameName(c.output, i)
+		}
+
+		if name == "-" {
+			_, err = stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(name, buf.Bytes(), 0o644)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !c.quiet {
+			b := img.Bounds()
+			fmt.Fprintf(stderr, "Saved file %s (%dx%d, %s)\n", name, b.Dx(), b.Dy(), strings.ToUpper(c.format))
+		}
+	}
+
+	return nil
+}
+
+// transform applies crop, scale and flip, in that order, as dwebp does.
+func transform(img image.Image, c config) (image.Image, error) {
+	if c.crop != "" {
+		r, err := cli.ParseCrop(c.crop)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err = cli.Crop(img, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.resize != "" {
+		w, h, err := cli.ParseSize(c.resize)
+		if err != nil {
+			return nil, err
+		}
+
+		img = resample.Resize(img, w, h)
+	}
+
+	if c.flip {
+		b := img.Bounds()
+		dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			draw.Draw(dst, image.Rect(0, b.Dy()-1-y, b.Dx(), b.Dy()-y), img, image.Pt(b.Min.X, b.Min.Y+y), draw.Src)
+		}
+		img = dst
+	}
+
+	return img, nil
+}
+
+func write(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "ppm":
+		return writePPM(w, img)
+	case "pam":
+		return writePAM(w, img)
+	case "tiff":
+		return writeTIFF(w, img)
+	case "yuv":
+		return writeYUV(w, img)
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// formatOf guesses the output format from the file extension, defaulting to PNG.
+func formatOf(name string) string {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".ppm", ".pam", ".yuv":
+		return ext[1:]
+	case ".tif", ".tiff":
+		return "tiff"
+	}
+
+	return "png"
+}
+
+// frameName inserts the frame number before the extension: out.png -> out-0001.png.
+func frameName(name string, i int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%04d%s", strings.TrimSuffix(name, ext), i+1, ext)
+}
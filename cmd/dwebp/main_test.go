@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+func writeWebp(t *testing.T, dir string) (string, *image.NRGBA) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 12), 90, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, webp.Options{Lossless: true, Exact: true, Backend: webp.BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "in.webp")
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name, img
+}
+
+func TestDwebpFormats(t *testing.T) {
+	dir := t.TempDir()
+	in, src := writeWebp(t, dir)
+
+	tests := []struct {
+		format string
+		size   int
+		header string
+	}{
+		{"ppm", 30 * 20 * 3, "P6\n30 20\n255\n"},
+		{"pam", 30 * 20 * 4, "P7\nWIDTH 30\nHEIGHT 20\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n"},
+		{"tiff", 30 * 20 * 4, "II*\x00"},
+		{"yuv", 30*20 + 2*15*10, ""},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := run([]string{"-quiet", "-" + tt.format, "-backend", "wasm", in, "-o", "-"}, nil, &out, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		data := out.Bytes()
+		if !bytes.HasPrefix(data, []byte(tt.header)) {
+			t.Errorf("%s: got header %q", tt.format, data[:min(len(data), 16)])
+		}
+
+		if tt.format == "tiff" {
+			if len(data) < tt.size || !bytes.Equal(data[len(data)-tt.size:], src.Pix) {
+				t.Errorf("tiff: pixel data differs")
+			}
+		} else if len(data)-len(tt.header) != tt.size {
+			t.Errorf("%s: got %d bytes of pixels, want %d", tt.format, len(data)-len(tt.header), tt.size)
+		}
+	}
+
+	out := filepath.Join(dir, "out.png")
+	if err := run([]string{"-quiet", "-crop", "2,4,20,10", "-resize", "10x0", "-flip", "-backend", "wasm", in, "-o", out}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 5 {
+		t.Errorf("got %v, want 10x5", b)
+	}
+}
+
+func TestDwebpFlip(t *testing.T) {
+	dir := t.TempDir()
+	in, src := writeWebp(t, dir)
+
+	var out bytes.Buffer
+	if err := run([]string{"-quiet", "-pam", "-flip", "-backend", "wasm", in, "-o", "-"}, nil, &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	pix := out.Bytes()[out.Len()-len(src.Pix):]
+	for y := 0; y < 20; y++ {
+		if !bytes.Equal(pix[y*120:(y+1)*120], src.Pix[(19-y)*120:(20-y)*120]) {
+			t.Fatalf("row %d not flipped", y)
+		}
+	}
+}
+
+func TestDwebpDump(t *testing.T) {
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "frame.png")
+	if err := run([]string{"-quiet", "-dump", "-backend", "wasm", "../../testdata/anim.webp", "-o", out}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "frame-*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 17 || filepath.Base(files[0]) != "frame-0001.png" {
+		t.Errorf("got %d files, first %v", len(files), files[:min(len(files), 1)])
+	}
+}
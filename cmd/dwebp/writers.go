@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// nrgba returns img as NRGBA with its origin at 0,0.
+func nrgba(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	return dst
+}
+
+// writePPM writes a binary PPM (P6); alpha is dropped.
+func writePPM(w io.Writer, img image.Image) error {
+	n := nrgba(img)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", n.Rect.Dx(), n.Rect.Dy())
+
+	for i := 0; i < len(n.Pix); i += 4 {
+		bw.Write(n.Pix[i : i+3])
+	}
+
+	return bw.Flush()
+}
+
+// writePAM writes a PAM (P7) with the RGB_ALPHA tuple type.
+func writePAM(w io.Writer, img image.Image) error {
+	n := nrgba(img)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", n.Rect.Dx(), n.Rect.Dy())
+	bw.Write(n.Pix)
+
+	return bw.Flush()
+}
+
+// writeTIFF writes an uncompressed little-endian baseline TIFF with unassociated alpha.
+func writeTIFF(w io.Writer, img image.Image) error {
+	n := nrgba(img)
+	width, height := n.Rect.Dx(), n.Rect.Dy()
+
+	const (
+		typeShort = 3
+		typeLong  = 4
+	)
+
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    uint32
+	}
+
+	const entries = 11
+	ifdOffset := uint32(8)
+	ifdSize := uint32(2 + entries*12 + 4)
+	bpsOffset := ifdOffset + ifdSize // 4 shorts of BitsPerSample
+	dataOffset := bpsOffset + 8
+
+	ifd := []entry{
+		{256, typeLong, 1, uint32(width)},      // ImageWidth
+		{257, typeLong, 1, uint32(height)},     // ImageLength
+		{258, typeShort, 4, bpsOffset},         // BitsPerSample
+		{259, typeShort, 1, 1},                 // Compression: none
+		{262, typeShort, 1, 2},                 // PhotometricInterpretation: RGB
+		{273, typeLong, 1, dataOffset},         // StripOffsets
+		{277, typeShort, 1, 4},                 // SamplesPerPixel
+		{278, typeLong, 1, uint32(height)},     // RowsPerStrip
+		{279, typeLong, 1, uint32(len(n.Pix))}, // StripByteCounts
+		{284, typeShort, 1, 1},                 // PlanarConfiguration: chunky
+		{338, typeShort, 1, 2},                 // ExtraSamples: unassociated alpha
+	}
+
+	var b []byte
+	b = append(b, 'I', 'I', 42, 0)
+	b = binary.LittleEndian.AppendUint32(b, ifdOffset)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(ifd)))
+
+	for _, e := range ifd {
+		b = binary.LittleEndian.AppendUint16(b, e.tag)
+		b = binary.LittleEndian.AppendUint16(b, e.typ)
+		b = binary.LittleEndian.AppendUint32(b, e.count)
+		if e.typ == typeShort && e.count == 1 {
+			b = binary.LittleEndian.AppendUint16(b, uint16(e.value))
+			b = append(b, 0, 0)
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, e.value)
+		}
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, 0) // no next IFD
+	for i := 0; i < 4; i++ {
+		b = binary.LittleEndian.AppendUint16(b, 8)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+
+	_, err := w.Write(n.Pix)
+
+	return err
+}
+
+// writeYUV writes the raw Y, U and V planes of 4:2:0 YCbCr, as dwebp -yuv does.
+func writeYUV(w io.Writer, img image.Image) error {
+	var m *image.YCbCr
+
+	switch v := img.(type) {
+	case *image.NYCbCrA:
+		m = &v.YCbCr
+	case *image.YCbCr:
+		m = v
+	}
+
+	if m == nil || m.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		m = toYCbCr(nrgba(img))
+	}
+
+	r := m.Rect
+	cw, ch := (r.Max.X+1)/2-r.Min.X/2, (r.Max.Y+1)/2-r.Min.Y/2
+
+	bw := bufio.NewWriter(w)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := m.YOffset(r.Min.X, y)
+		bw.Write(m.Y[i : i+r.Dx()])
+	}
+
+	for _, plane := range [][]byte{m.Cb, m.Cr} {
+		for y := 0; y < ch; y++ {
+			i := m.COffset(r.Min.X, r.Min.Y+2*y)
+			bw.Write(plane[i : i+cw])
+		}
+	}
+
+	return bw.Flush()
+}
+
+// toYCbCr converts n to 4:2:0 YCbCr, averaging the chroma of each 2x2 block.
+func toYCbCr(n *image.NRGBA) *image.YCbCr {
+	m := image.NewYCbCr(n.Rect, image.YCbCrSubsampleRatio420)
+	w, h := n.Rect.Dx(), n.Rect.Dy()
+
+	for y := 0; y < h; y += 2 {
+		for x := 0; x < w; x += 2 {
+			var cb, cr, count int
+			for dy := 0; dy < 2 && y+dy < h; dy++ {
+				for dx := 0; dx < 2 && x+dx < w; dx++ {
+					c := n.NRGBAAt(x+dx, y+dy)
+					yy, u, v := color.RGBToYCbCr(c.R, c.G, c.B)
+					m.Y[m.YOffset(x+dx, y+dy)] = yy
+					cb += int(u)
+					cr += int(v)
+					count++
+				}
+			}
+
+			i := m.COffset(x, y)
+			m.Cb[i] = uint8((cb + count/2) / count)
+			m.Cr[i] = uint8((cr + count/2) / count)
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,318 @@
+// Command img2webp builds an animated WEBP from a sequence of images, like libwebp's img2webp.
+//
+// Usage:
+//
+//	img2webp [file options] [[frame options] frame_file]... -o output.webp
+//	img2webp argument_file
+//
+// Frame options apply to all the frames that follow them, so "-d 80 -lossy a.png b.png
+// -lossless -d 200 c.png" encodes a and b lossy for 80ms and c lossless for 200ms. Frames
+// are lossless by default. Frame files may be glob patterns, expanded in lexical order, and
+// a single argument names a file the arguments are read from, one or more per line.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/webp"
+	"github.com/gen2brain/webp/internal/cli"
+)
+
+const usage = `Usage: img2webp [file options] [[frame options] frame_file]... -o output.webp
+
+File options:
+  -o string        output file (- for stdout)
+  -loop int        loop count (0 = infinite)
+  -kmin int        minimum distance between key frames
+  -kmax int        maximum distance between key frames (0 disables key frames)
+  -min_size        minimize the output size (slow), disables key frames
+  -mixed           use lossy or lossless per frame, whichever is smaller
+  -mt int          number of threads (0 = backend default)
+  -backend string  libwebp backend: auto, dynamic or wasm
+  -v               verbose, print the frames
+  -quiet           do not print anything
+
+Frame options:
+  -d int           frame duration in milliseconds (default 100)
+  -lossless        encode frames losslessly (default)
+  -lossy           encode frames lossy
+  -q float         quality factor (1:small..100:big) (default 75)
+  -m int           compression method (0=fast, 6=slowest) (default 4)
+  -exact           preserve RGB values in transparent area
+  -noexact         do not preserve RGB values in transparent area (default)
+`
+
+type frame struct {
+	file     string
+	duration int
+	opt      webp.Options
+}
+
+type config struct {
+	output  string
+	loop    int
+	opt     webp.Options
+	frames  []frame
+	verbose bool
+	quiet   bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "img2webp:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	// A lone argument is a file holding the real arguments, as with img2webp.
+	if len(args) == 1 && !strings.HasPrefix(args[0], "-") && !isGlob(args[0]) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		args = strings.Fields(string(data))
+	}
+
+	c, err := parse(args)
+	if err != nil {
+		if errors.Is(err, errHelp) {
+			fmt.Fprint(stderr, usage)
+			return nil
+		}
+
+		return err
+	}
+
+	anim := &webp.WEBP{
+		Image:     make([]image.Image, len(c.frames)),
+		Delay:     make([]int, len(c.frames)),
+		Options:   make([]*webp.FrameOptions, len(c.frames)),
+		LoopCount: c.loop,
+	}
+
+	for i, f := range c.frames {
+		img, err := decodeFile(f.file)
+		if err != nil {
+			return err
+		}
+
+		anim.Image[i] = img
+		anim.Delay[i] = f.duration
+		anim.Options[i] = frameOptions(&c.frames[i].opt)
+
+		if c.verbose && !c.quiet {
+			b := img.Bounds()
+			fmt.Fprintf(stderr, "Frame %d: %s, %dx%d, %dms, lossless %v, quality %d, method %d\n",
+				i+1, f.file, b.Dx(), b.Dy(), f.duration, f.opt.Lossless, f.opt.Quality, f.opt.Method)
+		}
+	}
+
+	// Frames sharing the first frame's settings need no per-frame encoding.
+	opt := c.opt
+	first := c.frames[0].opt
+	opt.Quality, opt.Method, opt.Lossless, opt.Exact = first.Quality, first.Method, first.Lossless, first.Exact
+
+	var buf bytes.Buffer
+	if err := webp.EncodeAll(&buf, anim, opt); err != nil {
+		return err
+	}
+
+	if c.output == "-" {
+		_, err = stdout.Write(buf.Bytes())
+	} else {
+		err = os.WriteFile(c.output, buf.Bytes(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !c.quiet {
+		fmt.Fprintf(stderr, "Saved file %s (%d frames, %d bytes)\n", c.output, len(c.frames), buf.Len())
+	}
+
+	return nil
+}
+
+// frameOptions returns the complete settings of a frame as overrides, so that nothing is
+// inherited from the options of the call.
+func frameOptions(o *webp.Options) *webp.FrameOptions {
+	quality := o.Quality
+	if quality <= 0 {
+		quality = webp.DefaultQuality
+	}
+
+	return &webp.FrameOptions{Quality: quality, Method: &o.Method, Lossless: &o.Lossless, Exact: &o.Exact}
+}
+
+var errHelp = errors.New("help requested")
+
+func parse(args []string) (*config, error) {
+	c := &config{}
+	cur := frame{duration: 100, opt: webp.Options{Quality: webp.DefaultQuality, Method: webp.DefaultMethod, Lossless: true}}
+
+	next := func(i *int) (string, error) {
+		*i++
+		if *i >= len(args) {
+			return "", fmt.Errorf("missing value after %s", args[*i-1])
+		}
+
+		return args[*i], nil
+	}
+
+	nextInt := func(i *int, lo, hi int) (int, error) {
+		s, err := next(i)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("invalid value %q for %s, want [%d,%d]", s, args[*i-1], lo, hi)
+		}
+
+		return n, nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		var err error
+
+		switch arg := args[i]; arg {
+		case "-h", "-help":
+			return nil, errHelp
+		case "-o":
+			c.output, err = next(&i)
+		case "-loop":
+			c.loop, err = nextInt(&i, 0, 0xffff)
+		case "-kmin":
+			c.opt.Kmin, err = nextInt(&i, 0, 1<<30)
+		case "-kmax":
+			c.opt.Kmax, err = nextInt(&i, 0, 1<<30)
+		case "-min_size":
+			c.opt.MinimizeSize = true
+		case "-mixed":
+			c.opt.AllowMixed = true
+		case "-mt":
+			c.opt.Threads, err = nextInt(&i, 0, 1<<10)
+		case "-backend":
+			var s string
+			if s, err = next(&i); err == nil {
+				c.opt.Backend, err = cli.ParseBackend(s)
+			}
+		case "-v":
+			c.verbose = true
+		case "-quiet":
+			c.quiet = true
+		case "-d":
+			cur.duration, err = nextInt(&i, 0, 1<<24-1)
+		case "-lossless":
+			cur.opt.Lossless = true
+		case "-lossy":
+			cur.opt.Lossless = false
+		case "-q":
+			var s string
+			if s, err = next(&i); err == nil {
+				q, perr := strconv.ParseFloat(s, 64)
+				if perr != nil {
+					return nil, fmt.Errorf("invalid quality %q", s)
+				}
+
+				cur.opt.Quality, err = cli.Quality(q)
+			}
+		case "-m":
+			var s string
+			if s, err = next(&i); err == nil {
+				m, perr := strconv.Atoi(s)
+				if perr != nil {
+					return nil, fmt.Errorf("invalid method %q", s)
+				}
+
+				if err = cli.CheckMethod(m); err == nil {
+					cur.opt.Method = m
+				}
+			}
+		case "-exact":
+			cur.opt.Exact = true
+		case "-noexact":
+			cur.opt.Exact = false
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return nil, fmt.Errorf("unknown option %s", arg)
+			}
+
+			files, err := expand(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, file := range files {
+				f := cur
+				f.file = file
+				c.frames = append(c.frames, f)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.frames) == 0 {
+		return nil, errors.New("no input frames")
+	}
+
+	if c.output == "" {
+		return nil, errors.New("missing output file (-o)")
+	}
+
+	return c, nil
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// expand returns the files matching a glob pattern in lexical order, or the name itself.
+func expand(name string) ([]string, error) {
+	if !isGlob(name) {
+		return []string{name}, nil
+	}
+
+	files, err := filepath.Glob(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no matching files", name)
+	}
+
+	return files, nil
+}
+
+func decodeFile(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+// writeFrames writes n distinct PNG frames to dir and returns their names.
+func writeFrames(t *testing.T, dir string, n int) []string {
+	t.Helper()
+
+	var names []string
+	for i := 0; i < n; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+		for y := 0; y < 24; y++ {
+			for x := 0; x < 32; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x*8 + i*40), uint8(y * 10), uint8(i * 80), 255})
+			}
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("frame-%02d.png", i))
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func inspect(t *testing.T, name string) *webp.Info {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := webp.Inspect(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
+
+func TestImg2webp(t *testing.T) {
+	dir := t.TempDir()
+	names := writeFrames(t, dir, 3)
+	out := filepath.Join(dir, "out.webp")
+
+	args := []string{"-quiet", "-loop", "3", "-backend", "wasm", "-d", "80", "-lossy", "-q", "60", names[0], names[1],
+		"-lossless", "-d", "200", names[2], "-o", out}
+	if err := run(args, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	info := inspect(t, out)
+	if len(info.Frames) != 3 || info.LoopCount != 3 {
+		t.Fatalf("got %d frames, loop %d", len(info.Frames), info.LoopCount)
+	}
+
+	for i, want := range []struct {
+		duration int
+		lossless bool
+	}{{80, false}, {80, false}, {200, true}} {
+		f := info.Frames[i]
+		if f.Duration != want.duration || f.Lossless != want.lossless {
+			t.Errorf("frame %d: got %dms lossless %v, want %dms lossless %v", i, f.Duration, f.Lossless, want.duration, want.lossless)
+		}
+	}
+}
+
+func TestImg2webpGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeFrames(t, dir, 4)
+	out := filepath.Join(dir, "out.webp")
+
+	// The argument file takes a glob, expanded in order.
+	argfile := filepath.Join(dir, "args.txt")
+	args := "-quiet -backend wasm -kmax 1\n-d 50 " + filepath.Join(dir, "frame-*.png") + "\n-o " + out + "\n"
+	if err := os.WriteFile(argfile, []byte(args), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{argfile}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	anim, err := webp.DecodeAll(f, webp.Options{ColorMode: webp.ModeNRGBA, Backend: webp.BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(anim.Image) != 4 {
+		t.Fatalf("got %d frames, want 4", len(anim.Image))
+	}
+
+	// Lossless frames decode exactly, in lexical order.
+	for i, img := range anim.Image {
+		want := color.NRGBA{uint8(8 + i*40), 10, uint8(i * 80), 255}
+		if c := img.(*image.NRGBA).NRGBAAt(1, 1); c != want || anim.Delay[i] != 50 {
+			t.Errorf("frame %d: got %v %dms, want %v 50ms", i, c, anim.Delay[i], want)
+		}
+	}
+}
+
+func TestImg2webpErrors(t *testing.T) {
+	dir := t.TempDir()
+	names := writeFrames(t, dir, 1)
+
+	for _, args := range [][]string{
+		{names[0]},
+		{"-o", "-"},
+		{"-q", "101", names[0], "-o", "-"},
+		{"-q", "0", names[0], "-o", "-"},
+		{"-q", "x", names[0], "-o", "-"},
+		{"-m", "7", names[0], "-o", "-"},
+		{"-unknown", names[0], "-o", "-"},
+		{filepath.Join(dir, "none-*.png"), "-o", "-"},
+		{"-backend", "gpu", names[0], "-o", "-"},
+	} {
+		if err := run(args, io.Discard, io.Discard); err == nil {
+			t.Errorf("%s: no error", strings.Join(args, " "))
+		}
+	}
+}
@@ -0,0 +1,448 @@
+// Command webpbatch converts a directory tree of PNG, JPEG and GIF images to WEBP.
+//
+// Usage:
+//
+//	webpbatch [options] input_dir output_dir
+//
+// The output tree mirrors the input tree, with each image.ext written as image.webp. Images
+// are encoded concurrently by -j workers. Outputs newer than their input are skipped, so an
+// interrupted run can be restarted, and files are written to a temporary name first so a
+// partial output is never mistaken for a finished one. When the WEBP is not smaller than the
+// original, the original is copied instead. Inputs that differ only in their extension, such
+// as a.png and a.jpg, would share a.webp, so only the first in walk order is converted and the
+// others are reported as failures. A summary of the bytes saved and of the failures
+// is printed at the end, and optionally written as JSON with -report.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/webp"
+	"github.com/gen2brain/webp/internal/cli"
+)
+
+type config struct {
+	quality  int
+	method   int
+	lossless bool
+	exact    bool
+	workers  int
+	exts     string
+	force    bool
+	report   string
+	backend  string
+	verbose  bool
+	quiet    bool
+}
+
+// status is the outcome of one file.
+type status string
+
+const (
+	converted status = "converted"
+	kept      status = "kept"
+	skipped   status = "skipped"
+	failed    status = "failed"
+)
+
+type result struct {
+	input  string
+	output string
+	status status
+	in     int64
+	out    int64
+	err    error
+}
+
+// Failure is a file that could not be converted.
+type Failure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Report summarizes a run.
+type Report struct {
+	Converted   int       `json:"converted"`
+	Kept        int       `json:"kept"`
+	Skipped     int       `json:"skipped"`
+	Failed      int       `json:"failed"`
+	InputBytes  int64     `json:"input_bytes"`
+	OutputBytes int64     `json:"output_bytes"`
+	SavedBytes  int64     `json:"saved_bytes"`
+	Duration    string    `json:"duration"`
+	Failures    []Failure `json:"failures,omitempty"`
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "webpbatch:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	var c config
+
+	fs := flag.NewFlagSet("webpbatch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.IntVar(&c.quality, "q", webp.DefaultQuality, "quality factor (1:small..100:big)")
+	fs.IntVar(&c.method, "m", webp.DefaultMethod, "compression method (0=fast, 6=slowest)")
+	fs.BoolVar(&c.lossless, "lossless", false, "encode images losslessly")
+	fs.BoolVar(&c.exact, "exact", false, "preserve RGB values in transparent area")
+	fs.IntVar(&c.workers, "j", runtime.NumCPU(), "number of concurrent workers")
+	fs.StringVar(&c.exts, "ext", "png,jpg,jpeg,gif", "comma separated list of file extensions to convert")
+	fs.BoolVar(&c.force, "force", false, "convert even when the output is newer than the input")
+	fs.StringVar(&c.report, "report", "", "write the summary as JSON to this file")
+	fs.StringVar(&c.backend, "backend", "auto", "libwebp backend: auto, dynamic or wasm")
+	fs.BoolVar(&c.verbose, "v", false, "verbose, print every file")
+	fs.BoolVar(&c.quiet, "quiet", false, "do not print anything")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: webpbatch [options] input_dir output_dir")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected an input and an output directory")
+	}
+
+	opt, err := options(c)
+	if err != nil {
+		return err
+	}
+
+	exts := make(map[string]bool)
+	for _, e := range strings.Split(c.exts, ",") {
+		if e = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(e)), "."); e != "" {
+			exts["."+e] = true
+		}
+	}
+
+	start := time.Now()
+	report, err := convertTree(fs.Arg(0), fs.Arg(1), exts, opt, c, stderr)
+	if err != nil {
+		return err
+	}
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	if c.report != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(c.report, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+
+	if !c.quiet {
+		printReport(stdout, report)
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d files failed", report.Failed)
+	}
+
+	return nil
+}
+
+func options(c config) (webp.Options, error) {
+	opt := webp.Options{Quality: c.quality, Method: c.method, Lossless: c.lossless, Exact: c.exact, Threads: 1}
+
+	if c.quality < 1 || c.quality > 100 {
+		return opt, fmt.Errorf("quality %d out of range [1,100]", c.quality)
+	}
+	if c.method < 0 || c.method > 6 {
+		return opt, fmt.Errorf("method %d out of range [0,6]", c.method)
+	}
+	if c.workers < 1 {
+		return opt, fmt.Errorf("invalid worker count %d", c.workers)
+	}
+
+	var err error
+	opt.Backend, err = cli.ParseBackend(c.backend)
+
+	return opt, err
+}
+
+// convertTree walks src and converts the matching files into dst with c.workers workers.
+// Files are streamed to the workers as they are found, so memory does not grow with the tree.
+func convertTree(src, dst string, exts map[string]bool, opt webp.Options, c config, stderr io.Writer) (*Report, error) {
+	if fi, err := os.Stat(src); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", src)
+	}
+
+	jobs := make(chan string, c.workers)
+	results := make(chan result, c.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				results <- convertFile(src, dst, rel, opt, c.force)
+			}
+		}()
+	}
+
+	// outputs maps each WEBP output to the input that claimed it.
+	outputs := make(map[string]string)
+
+	var walkErr error
+	go func() {
+		walkErr = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// An unreadable directory is reported as a failure and the walk goes on.
+				results <- result{input: path, status: failed, err: err}
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			// The output tree may live inside the input tree.
+			if d.IsDir() && path != src && sameFile(path, dst) {
+				return fs.SkipDir
+			}
+
+			if d.IsDir() || !exts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+
+			name := webpName(rel)
+			if first, ok := outputs[name]; ok {
+				results <- result{input: path, status: failed, err: fmt.Errorf("output %s is already written from %s", name, first)}
+				return nil
+			}
+			outputs[name] = rel
+
+			jobs <- rel
+
+			return nil
+		})
+
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &Report{}
+	for r := range results {
+		switch r.status {
+		case converted:
+			report.Converted++
+		case kept:
+			report.Kept++
+		case skipped:
+			report.Skipped++
+		case failed:
+			report.Failed++
+			report.Failures = append(report.Failures, Failure{File: r.input, Error: r.err.Error()})
+		}
+
+		if r.status == converted || r.status == kept {
+			report.InputBytes += r.in
+			report.OutputBytes += r.out
+		}
+
+		if !c.quiet && (c.verbose || r.status == failed) {
+			if r.err != nil {
+				fmt.Fprintf(stderr, "%s: %s: %v\n", r.status, r.input, r.err)
+			} else {
+				fmt.Fprintf(stderr, "%s: %s -> %s (%d -> %d bytes)\n", r.status, r.input, r.output, r.in, r.out)
+			}
+		}
+	}
+
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	report.SavedBytes = report.InputBytes - report.OutputBytes
+	slices.SortFunc(report.Failures, func(a, b Failure) int { return strings.Compare(a.File, b.File) })
+
+	return report, nil
+}
+
+// convertFile converts src/rel to dst/rel with a .webp extension, or copies the original
+// to dst/rel when the WEBP would not be smaller.
+func convertFile(src, dst, rel string, opt webp.Options, force bool) result {
+	input := filepath.Join(src, rel)
+	output := filepath.Join(dst, webpName(rel))
+	original := filepath.Join(dst, rel)
+
+	r := result{input: input, output: output}
+
+	fi, err := os.Stat(input)
+	if err != nil {
+		r.status, r.err = failed, err
+		return r
+	}
+
+	if !force {
+		for _, name := range []string{output, original} {
+			if o, err := os.Stat(name); err == nil && o.ModTime().After(fi.ModTime()) {
+				r.output, r.status = name, skipped
+				return r
+			}
+		}
+	}
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		r.status, r.err = failed, err
+		return r
+	}
+
+	out, err := encode(data, opt)
+	if err != nil {
+		r.status, r.err = failed, err
+		return r
+	}
+
+	r.in, r.status = int64(len(data)), converted
+	if len(out) >= len(data) {
+		out, output, r.status = data, original, kept
+	}
+
+	r.output, r.out = output, int64(len(out))
+
+	if err := writeFile(output, out); err != nil {
+		r.status, r.err = failed, err
+		return r
+	}
+
+	// Remove a stale output of the other kind from an earlier run. When converting in place the
+	// original output is the input itself, which must be kept.
+	stale := original
+	if r.status == kept {
+		stale = filepath.Join(dst, webpName(rel))
+	}
+	if stale != output && !sameFile(stale, input) {
+		os.Remove(stale)
+	}
+
+	return r
+}
+
+// webpName returns the WEBP output path of the input path rel.
+func webpName(rel string) string {
+	return strings.TrimSuffix(rel, filepath.Ext(rel)) + ".webp"
+}
+
+// encode encodes an image file; animated GIFs keep their animation.
+func encode(data []byte, opt webp.Options) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+		if err := webp.EncodeAll(&buf, webp.FromGIF(g), opt); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := webp.Encode(&buf, img, opt); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sameFile reports whether a and b name the same existing file or directory.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fa, fb)
+}
+
+// writeFile writes data to a temporary file next to name and renames it into place.
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".webpbatch-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+func printReport(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "Converted: %d\n", r.Converted)
+	fmt.Fprintf(w, "Kept:      %d (WebP not smaller)\n", r.Kept)
+	fmt.Fprintf(w, "Skipped:   %d (up to date)\n", r.Skipped)
+	fmt.Fprintf(w, "Failed:    %d\n", r.Failed)
+
+	saved := 0.0
+	if r.InputBytes > 0 {
+		saved = 100 * float64(r.SavedBytes) / float64(r.InputBytes)
+	}
+	fmt.Fprintf(w, "Size:      %d -> %d bytes, saved %d bytes (%.1f%%)\n", r.InputBytes, r.OutputBytes, r.SavedBytes, saved)
+	fmt.Fprintf(w, "Time:      %s\n", r.Duration)
+
+	for _, f := range r.Failures {
+		fmt.Fprintf(w, "  %s: %s\n", f.File, f.Error)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testTree writes a PNG that shrinks as WEBP, a noisy low quality JPEG that grows when
+// encoded losslessly, and a broken PNG.
+func testTree(t *testing.T, src string) {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(src, "a.png"), buf.Bytes())
+
+	rng := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+
+	buf.Reset()
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 5}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(src, "sub", "b.jpg"), buf.Bytes())
+
+	writeTestFile(t, filepath.Join(src, "sub", "deep", "broken.png"), []byte("not a png"))
+	writeTestFile(t, filepath.Join(src, "notes.txt"), []byte("ignored"))
+}
+
+func TestWebpbatch(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	testTree(t, src)
+
+	reportFile := filepath.Join(dir, "report.json")
+	args := []string{"-lossless", "-j", "3", "-backend", "wasm", "-report", reportFile, src, dst}
+
+	var out bytes.Buffer
+	if err := run(args, &out, io.Discard); err == nil {
+		t.Error("broken file not reported")
+	}
+
+	for _, name := range []string{"a.webp", "sub/b.jpg"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, name := range []string{"a.png", "sub/b.webp", "notes.webp", "sub/deep/broken.webp"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("unexpected output %s", name)
+		}
+	}
+
+	var r Report
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Converted != 1 || r.Kept != 1 || r.Failed != 1 || r.Skipped != 0 || len(r.Failures) != 1 {
+		t.Errorf("got report %+v", r)
+	}
+	if r.SavedBytes <= 0 || r.SavedBytes != r.InputBytes-r.OutputBytes {
+		t.Errorf("got %d saved bytes of %d", r.SavedBytes, r.InputBytes)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("broken.png")) {
+		t.Errorf("summary lacks the failure:\n%s", out.String())
+	}
+
+	// A second run skips the up to date outputs and converts a touched input again.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "a.png"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(src, "sub", "deep", "broken.png"))
+
+	if err := run(args, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err = os.ReadFile(reportFile); err != nil {
+		t.Fatal(err)
+	}
+	r = Report{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Converted != 1 || r.Skipped != 1 || r.Kept != 0 || r.Failed != 0 {
+		t.Errorf("got report %+v", r)
+	}
+}
+
+func TestWebpbatchErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, args := range [][]string{
+		{dir},
+		{"-q", "0", dir, dir},
+		{"-j", "0", dir, dir},
+		{filepath.Join(dir, "missing"), dir},
+	} {
+		if err := run(args, io.Discard, io.Discard); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestWebpbatchDuplicateOutput(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	testTree(t, src)
+
+	// a.jpg sorts first and claims a.webp; being kept as a JPEG, it must not remove a
+	// WEBP written for a.png.
+	b, err := os.ReadFile(filepath.Join(src, "sub", "b.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(src, "a.jpg"), b)
+	os.Remove(filepath.Join(src, "sub", "deep", "broken.png"))
+
+	reportFile := filepath.Join(dir, "report.json")
+	if err := run([]string{"-lossless", "-backend", "wasm", "-report", reportFile, src, dst}, io.Discard, io.Discard); err == nil {
+		t.Error("duplicate output not reported")
+	}
+
+	var r Report
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Kept != 2 || r.Failed != 1 || len(r.Failures) != 1 || r.Failures[0].File != filepath.Join(src, "a.png") {
+		t.Errorf("got report %+v", r)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a.jpg")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebpbatchInPlace(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "lib")
+	testTree(t, src)
+	os.Remove(filepath.Join(src, "sub", "deep", "broken.png"))
+
+	if err := run([]string{"-lossless", "-backend", "wasm", src, src}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inputs are kept next to the converted output.
+	for _, name := range []string{"a.png", "a.webp", "sub/b.jpg"} {
+		if _, err := os.Stat(filepath.Join(src, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Command webpinfo prints the structure of WEBP files, like libwebp's webpinfo.
+//
+// Usage:
+//
+//	webpinfo [-json] [-summary] file.webp...
+//
+// It lists the RIFF chunk tree, the VP8X features, canvas and animation parameters, every
+// frame's placement and format, an EXIF summary and any validation warnings.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gen2brain/webp"
+)
+
+// report is the JSON output for one file.
+type report struct {
+	File string `json:"file"`
+	*webp.Info
+	Exif  *webp.Exif `json:"exif,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "webpinfo:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("webpinfo", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print a JSON array with one object per file")
+	summary := fs.Bool("summary", false, "print the summary only, without the chunk tree")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: webpinfo [options] file.webp...")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no input files")
+	}
+
+	reports := make([]report, 0, fs.NArg())
+	failed := 0
+
+	for _, name := range fs.Args() {
+		r := inspect(name)
+		if r.Error != "" {
+			failed++
+		}
+
+		if *asJSON {
+			reports = append(reports, r)
+			continue
+		}
+
+		printReport(stdout, r, *summary)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files are invalid", failed, fs.NArg())
+	}
+
+	return nil
+}
+
+func inspect(name string) report {
+	r := report{File: name}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	r.Info, err = webp.Inspect(bytes.NewReader(data))
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	if r.Info.Partial {
+		r.Error = r.Info.Warnings[0]
+		return r
+	}
+
+	if r.Info.EXIF {
+		if x, err := webp.DecodeExif(bytes.NewReader(data)); err == nil {
+			r.Exif = x
+		} else {
+			r.Info.Warnings = append(r.Info.Warnings, "EXIF: "+err.Error())
+		}
+	}
+
+	return r
+}
+
+func printReport(w io.Writer, r report, summary bool) {
+	fmt.Fprintf(w, "File: %s\n", r.File)
+
+	if r.Error != "" {
+		fmt.Fprintf(w, "  Error: %s\n", r.Error)
+
+		// A partial Info lists the chunks read before the error.
+		if r.Info != nil && !summary {
+			for _, ch := range r.Info.Chunks {
+				fmt.Fprintf(w, "Chunk %s at offset %d, length %d\n", ch.FourCC, ch.Offset, ch.Size+8)
+			}
+		}
+
+		fmt.Fprintln(w)
+		return
+	}
+
+	info := r.Info
+
+	if !summary {
+		fmt.Fprintf(w, "RIFF HEADER:\n  File size: %d\n", info.FileSize)
+
+		frame := 0
+		for _, ch := range info.Chunks {
+			fmt.Fprintf(w, "Chunk %s at offset %d, length %d\n", ch.FourCC, ch.Offset, ch.Size+8)
+
+			switch ch.FourCC {
+			case "VP8X":
+				fmt.Fprintf(w, "  ICCP: %d\n  Alpha: %d\n  EXIF: %d\n  XMP: %d\n  Animation: %d\n",
+					b2i(info.ICC), b2i(info.Alpha), b2i(info.EXIF), b2i(info.XMP), b2i(info.Animation))
+				fmt.Fprintf(w, "  Canvas size %d x %d\n", info.Width, info.Height)
+			case "ANIM":
+				bg := info.Background
+				fmt.Fprintf(w, "  Background color: 0x%02X%02X%02X%02X  Loop Count: %d\n", bg.A, bg.R, bg.G, bg.B, info.LoopCount)
+			case "ANMF":
+				if frame < len(info.Frames) {
+					f := info.Frames[frame]
+					fmt.Fprintf(w, "  Offset_X: %d\n  Offset_Y: %d\n  Width: %d\n  Height: %d\n  Duration: %d\n  Dispose: %d\n  Blend: %d\n",
+						f.X, f.Y, f.Width, f.Height, f.Duration, b2i(f.Dispose), b2i(f.Blend))
+				}
+				frame++
+
+				for _, sub := range ch.Chunks {
+					fmt.Fprintf(w, "  Chunk %s at offset %d, length %d\n", sub.FourCC, sub.Offset, sub.Size+8)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "Summary:")
+	fmt.Fprintf(w, "  Canvas: %d x %d\n", info.Width, info.Height)
+	fmt.Fprintf(w, "  Format: %s\n", format(info))
+	fmt.Fprintf(w, "  Alpha: %v\n", info.Alpha)
+
+	if info.Animation {
+		fmt.Fprintf(w, "  Frames: %d\n  Loop count: %d\n", len(info.Frames), info.LoopCount)
+
+		duration := 0
+		for _, f := range info.Frames {
+			duration += f.Duration
+		}
+		fmt.Fprintf(w, "  Duration: %d ms\n", duration)
+	}
+
+	if x := r.Exif; x != nil {
+		fmt.Fprintln(w, "EXIF:")
+		printField(w, "Orientation", x.Orientation)
+		printField(w, "Camera", strings.TrimSpace(x.Make+" "+x.Model))
+		printField(w, "Software", x.Software)
+		printField(w, "Date", x.DateTimeOriginal)
+		if x.ExposureTime > 0 {
+			printField(w, "Exposure", fmt.Sprintf("%gs f/%g ISO %d", x.ExposureTime, x.FNumber, x.ISOSpeed))
+		}
+		if x.GPSLatitude != 0 || x.GPSLongitude != 0 {
+			printField(w, "GPS", fmt.Sprintf("%.6f, %.6f", x.GPSLatitude, x.GPSLongitude))
+		}
+		printField(w, "Artist", x.Artist)
+		printField(w, "Copyright", x.Copyright)
+	}
+
+	if len(info.Warnings) > 0 {
+		fmt.Fprintln(w, "Warnings:")
+		for _, warning := range info.Warnings {
+			fmt.Fprintf(w, "  %s\n", warning)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+// format is lossy, lossless or mixed, over all frames.
+func format(info *webp.Info) string {
+	lossy, lossless := false, false
+	for _, f := range info.Frames {
+		lossy = lossy || !f.Lossless
+		lossless = lossless || f.Lossless
+	}
+
+	switch {
+	case lossy && lossless:
+		return "Mixed"
+	case lossless:
+		return "Lossless"
+	}
+
+	return "Lossy"
+}
+
+func printField(w io.Writer, name string, v any) {
+	if v == "" || v == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "  %s: %v\n", name, v)
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWebpinfo(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"../../testdata/anim.webp", "../../testdata/exif.webp"}, &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	s := out.String()
+	for _, want := range []string{"Chunk ANMF at offset", "Animation: 1", "Frames: 17", "EXIF:\n  Orientation:"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+}
+
+func TestWebpinfoJSON(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.webp")
+	if err := os.WriteFile(bad, []byte("RIFF\x04\x00\x00\x00WEBP"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := run([]string{"-json", "../../testdata/anim.webp", bad}, &out, io.Discard)
+	if err == nil {
+		t.Error("invalid file not reported")
+	}
+
+	var reports []struct {
+		File      string `json:"file"`
+		Animation bool   `json:"animation"`
+		Frames    []struct {
+			Duration int `json:"duration"`
+		} `json:"frames"`
+		Error string `json:"error"`
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 2 || !reports[0].Animation || len(reports[0].Frames) != 17 || reports[1].Error == "" {
+		t.Errorf("got %+v", reports)
+	}
+}
+
+func TestWebpinfoTruncated(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/anim.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "truncated.webp")
+	if err := os.WriteFile(name, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{name}, &out, io.Discard); err == nil {
+		t.Error("truncated file not reported")
+	}
+
+	s := out.String()
+	for _, want := range []string{"Error: webp: decode failed", "Chunk VP8X at offset 12", "Chunk ANIM"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("output lacks %q:\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Command webpmux manipulates the chunks of WEBP files, like libwebp's webpmux.
+//
+// Usage:
+//
+//	webpmux -get exif|xmp|icc|frame N input.webp -o output
+//	webpmux -set exif|xmp|icc file input.webp -o output.webp
+//	webpmux -set loop N input.webp -o output.webp
+//	webpmux -set bgcolor A,R,G,B input.webp -o output.webp
+//	webpmux -strip exif|xmp|icc input.webp -o output.webp
+//	webpmux -frame file.webp +d[+x+y[+m[b]]] ... [-loop N] [-bgcolor A,R,G,B] -o output.webp
+//
+// For -frame, d is the duration in milliseconds, x and y the (even) offset, m the dispose
+// method (0 none, 1 background) and b the blending (+b blend, -b no blend).
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/webp"
+)
+
+type command struct {
+	action string // get, set, strip or frame
+	kind   string // exif, xmp, icc, frame, loop or bgcolor
+	value  string // argument of -get frame and -set
+
+	frames     []webp.MuxFrame
+	loopCount  int
+	background color.Color
+
+	input  string
+	output string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "webpmux:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	c, err := parse(args)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if c.action == "frame" {
+		var buf bytes.Buffer
+		if err := webp.Assemble(&buf, c.frames, c.loopCount, c.background); err != nil {
+			return err
+		}
+
+		out = buf.Bytes()
+	} else {
+		data, err := readFile(c.input, stdin)
+		if err != nil {
+			return err
+		}
+
+		out, err = apply(c, data, stdin)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.output == "-" {
+		_, err = stdout.Write(out)
+		return err
+	}
+
+	return os.WriteFile(c.output, out, 0o644)
+}
+
+// apply runs a get, set or strip command on data.
+func apply(c *command, data []byte, stdin io.Reader) ([]byte, error) {
+	if c.kind == "frame" {
+		n, err := strconv.Atoi(c.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame number %q", c.value)
+		}
+
+		// Frames are numbered from 1, as in webpmux.
+		return webp.ExtractFrame(bytes.NewReader(data), n-1)
+	}
+
+	if c.kind == "loop" || c.kind == "bgcolor" {
+		info, err := webp.Inspect(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if info.Partial {
+			return nil, errors.New(info.Warnings[0])
+		}
+
+		loop, bg := info.LoopCount, color.Color(info.Background)
+		if c.kind == "loop" {
+			if loop, err = parseLoop(c.value); err != nil {
+				return nil, err
+			}
+		} else if bg, err = parseColor(c.value); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := webp.SetAnimParams(&buf, bytes.NewReader(data), loop, bg); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	m, err := webp.ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	field := map[string]*[]byte{"exif": &m.EXIF, "xmp": &m.XMP, "icc": &m.ICCP}[c.kind]
+
+	switch c.action {
+	case "get":
+		if *field == nil {
+			return nil, fmt.Errorf("no %s chunk", c.kind)
+		}
+
+		return *field, nil
+	case "set":
+		if *field, err = readFile(c.value, stdin); err != nil {
+			return nil, err
+		}
+	case "strip":
+		*field = nil
+	}
+
+	var buf bytes.Buffer
+	if err := webp.SetMetadata(&buf, bytes.NewReader(data), m); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func parse(args []string) (*command, error) {
+	c := &command{}
+
+	next := func(i *int, what string) (string, error) {
+		*i++
+		if *i >= len(args) {
+			return "", fmt.Errorf("missing %s after %s", what, args[*i-1])
+		}
+
+		return args[*i], nil
+	}
+
+	setAction := func(action string) error {
+		if c.action != "" && c.action != action {
+			return fmt.Errorf("-%s cannot be combined with -%s", action, c.action)
+		}
+
+		c.action = action
+
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		var err error
+
+		switch arg := args[i]; arg {
+		case "-get", "-set", "-strip":
+			if err := setAction(arg[1:]); err != nil {
+				return nil, err
+			}
+
+			if c.kind, err = next(&i, "chunk type"); err != nil {
+				return nil, err
+			}
+
+			if !validKind(c.action, c.kind) {
+				return nil, fmt.Errorf("invalid %s type %q", arg, c.kind)
+			}
+
+			if c.action == "set" || c.kind == "frame" {
+				if c.value, err = next(&i, "value"); err != nil {
+					return nil, err
+				}
+			}
+		case "-frame":
+			if err := setAction("frame"); err != nil {
+				return nil, err
+			}
+
+			file, err := next(&i, "frame file")
+			if err != nil {
+				return nil, err
+			}
+
+			params, err := next(&i, "frame parameters")
+			if err != nil {
+				return nil, err
+			}
+
+			f, err := parseFrame(params)
+			if err != nil {
+				return nil, err
+			}
+
+			if f.Data, err = os.ReadFile(file); err != nil {
+				return nil, err
+			}
+
+			c.frames = append(c.frames, f)
+		case "-loop":
+			v, err := next(&i, "loop count")
+			if err != nil {
+				return nil, err
+			}
+
+			if c.loopCount, err = parseLoop(v); err != nil {
+				return nil, err
+			}
+		case "-bgcolor":
+			v, err := next(&i, "color")
+			if err != nil {
+				return nil, err
+			}
+
+			if c.background, err = parseColor(v); err != nil {
+				return nil, err
+			}
+		case "-o":
+			if c.output, err = next(&i, "output file"); err != nil {
+				return nil, err
+			}
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return nil, fmt.Errorf("unknown option %s", arg)
+			}
+
+			if c.input != "" {
+				return nil, errors.New("more than one input file")
+			}
+
+			c.input = arg
+		}
+	}
+
+	switch {
+	case c.action == "":
+		return nil, errors.New("no command, use -get, -set, -strip or -frame")
+	case c.output == "":
+		return nil, errors.New("missing output file (-o)")
+	case c.action != "frame" && c.input == "":
+		return nil, errors.New("missing input file")
+	}
+
+	return c, nil
+}
+
+func validKind(action, kind string) bool {
+	switch kind {
+	case "exif", "xmp", "icc":
+		return true
+	case "frame":
+		return action == "get"
+	case "loop", "bgcolor":
+		return action == "set"
+	}
+
+	return false
+}
+
+// parseFrame parses the +d[+x+y[+m[b]]] frame parameters of webpmux.
+func parseFrame(s string) (webp.MuxFrame, error) {
+	f := webp.MuxFrame{Blend: true}
+
+	// The blend flag is a trailing "+b" or "-b" on the dispose method.
+	if strings.HasSuffix(s, "-b") {
+		f.Blend = false
+		s = strings.TrimSuffix(s, "-b")
+	} else {
+		s = strings.TrimSuffix(s, "+b")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(s, "+"), "+")
+	if len(parts) != 1 && len(parts) != 3 && len(parts) != 4 {
+		return f, fmt.Errorf("invalid frame parameters %q, want +d[+x+y[+m[b]]]", s)
+	}
+
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("invalid frame parameters %q, want +d[+x+y[+m[b]]]", s)
+		}
+		v[i] = n
+	}
+
+	f.Duration, f.X, f.Y = v[0], v[1], v[2]
+	f.Dispose = v[3] == 1
+
+	return f, nil
+}
+
+func parseLoop(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 0xffff {
+		return 0, fmt.Errorf("invalid loop count %q", s)
+	}
+
+	return n, nil
+}
+
+// parseColor parses A,R,G,B with components in [0,255].
+func parseColor(s string) (color.Color, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid color %q, want A,R,G,B", s)
+	}
+
+	var v [4]uint8
+	for i, p := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid color %q, want A,R,G,B", s)
+		}
+		v[i] = uint8(n)
+	}
+
+	return color.NRGBA{R: v[1], G: v[2], B: v[3], A: v[0]}, nil
+}
+
+func readFile(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+
+	return os.ReadFile(name)
+}
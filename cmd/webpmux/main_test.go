@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+func TestWebpmuxXMP(t *testing.T) {
+	dir := t.TempDir()
+	xmp := filepath.Join(dir, "rights.xmp")
+	out := filepath.Join(dir, "out.webp")
+
+	data := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:rights>(c) Example</dc:rights></x:xmpmeta>`)
+	if err := os.WriteFile(xmp, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"-set", "xmp", xmp, "../../testdata/test.webp", "-o", out}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	if err := run([]string{"-get", "xmp", out, "-o", "-"}, nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("got XMP %q", got.Bytes())
+	}
+
+	if err := run([]string{"-strip", "xmp", out, "-o", out}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"-get", "xmp", out, "-o", "-"}, nil, &got); err == nil {
+		t.Error("stripped XMP still present")
+	}
+}
+
+func TestWebpmuxFrames(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "1.webp")
+	second := filepath.Join(dir, "2.webp")
+	out := filepath.Join(dir, "anim.webp")
+
+	if err := run([]string{"-get", "frame", "1", "../../testdata/anim.webp", "-o", first}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"-get", "frame", "2", "../../testdata/anim.webp", "-o", second}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-frame", first, "+100", "-frame", second, "+250+0+0+1-b", "-loop", "2", "-bgcolor", "255,10,20,30", "-o", out}
+	if err := run(args, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	info := inspect(t, out)
+	if len(info.Frames) != 2 || info.Frames[0].Duration != 100 || info.Frames[1].Duration != 250 {
+		t.Fatalf("got frames %+v", info.Frames)
+	}
+	if info.Frames[1].Blend || !info.Frames[1].Dispose || info.LoopCount != 2 {
+		t.Errorf("got frame %+v loop %d", info.Frames[1], info.LoopCount)
+	}
+	if info.Background != (color.NRGBA{10, 20, 30, 255}) {
+		t.Errorf("got background %v", info.Background)
+	}
+
+	if err := run([]string{"-set", "loop", "0", out, "-o", out}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if info := inspect(t, out); info.LoopCount != 0 || info.Background != (color.NRGBA{10, 20, 30, 255}) {
+		t.Errorf("got loop %d background %v", info.LoopCount, info.Background)
+	}
+}
+
+func TestWebpmuxErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"../../testdata/test.webp", "-o", "-"},
+		{"-get", "xmp", "../../testdata/test.webp"},
+		{"-get", "loop", "../../testdata/test.webp", "-o", "-"},
+		{"-frame", "../../testdata/test.webp", "+100+1+2", "-o", "-"},
+		{"-frame", "../../testdata/test.webp", "+x", "-o", "-"},
+		{"-set", "bgcolor", "1,2,3", "../../testdata/anim.webp", "-o", "-"},
+	} {
+		if err := run(args, nil, &bytes.Buffer{}); err == nil {
+			t.Errorf("%s: no error", strings.Join(args, " "))
+		}
+	}
+}
+
+func inspect(t *testing.T, name string) *webp.Info {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := webp.Inspect(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
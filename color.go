@@ -0,0 +1,210 @@
+package webp
+
+import (
+	"image"
+	"image/color"
+)
+
+// ColorMode selects the pixel format of decoded images.
+type ColorMode int
+
+const (
+	// ModeDefault decodes still images to *image.NYCbCrA (4:2:0) and animations to *image.RGBA.
+	ModeDefault ColorMode = iota
+	// ModeRGBA decodes to alpha-premultiplied *image.RGBA (libwebp MODE_rgbA).
+	ModeRGBA
+	// ModeNRGBA decodes to non-premultiplied *image.NRGBA. The dynamic backend decodes to libwebp
+	// MODE_RGBA, so translucent pixels keep their exact color. The wasm backend only gets
+	// premultiplied output from libwebp and divides it back by alpha: opaque pixels are exact,
+	// fully transparent ones lose their color, and a channel of a pixel with alpha a may be off
+	// by up to 255/a, rounded up.
+	ModeNRGBA
+	// ModeYCbCrA decodes to *image.NYCbCrA with 4:2:0 chroma (libwebp MODE_YUVA).
+	ModeYCbCrA
+	// ModeGray decodes to *image.Gray, using the same luma weights as color.GrayModel. The luma
+	// is that of the premultiplied color, i.e. the image composited over black, and alpha is
+	// dropped; both backends compute it the same way.
+	ModeGray
+)
+
+// String returns the name of the color mode.
+func (c ColorMode) String() string {
+	switch c {
+	case ModeDefault:
+		return "default"
+	case ModeRGBA:
+		return "RGBA"
+	case ModeNRGBA:
+		return "NRGBA"
+	case ModeYCbCrA:
+		return "YCbCrA"
+	case ModeGray:
+		return "Gray"
+	}
+
+	return "unknown"
+}
+
+// colorModel returns the color.Model of images decoded with mode c.
+func (c ColorMode) colorModel(hasAnimation bool) color.Model {
+	switch c {
+	case ModeRGBA:
+		return color.RGBAModel
+	case ModeNRGBA:
+		return color.NRGBAModel
+	case ModeYCbCrA:
+		return color.NYCbCrAModel
+	case ModeGray:
+		return color.GrayModel
+	}
+
+	if hasAnimation {
+		return color.RGBAModel
+	}
+
+	return color.NYCbCrAModel
+}
+
+// yuva reports whether a still image in mode c is decoded through the YUVA planes.
+func (c ColorMode) yuva() bool {
+	return c == ModeDefault || c == ModeYCbCrA
+}
+
+// fromRGBA converts a premultiplied frame to the pixel format of mode c, reusing src where possible.
+func (c ColorMode) fromRGBA(src *image.RGBA) image.Image {
+	switch c {
+	case ModeNRGBA:
+		return rgbaToNRGBA(src)
+	case ModeYCbCrA:
+		return nrgbaToNYCbCrA(rgbaToNRGBA(src))
+	case ModeGray:
+		return rgbaToGray(src)
+	}
+
+	return src
+}
+
+// fromNRGBA converts a non-premultiplied frame to the pixel format of mode c, reusing src where possible.
+func (c ColorMode) fromNRGBA(src *image.NRGBA) image.Image {
+	switch c {
+	case ModeDefault, ModeRGBA:
+		return nrgbaToRGBA(src)
+	case ModeYCbCrA:
+		return nrgbaToNYCbCrA(src)
+	case ModeGray:
+		return rgbaToGray(nrgbaToRGBA(src))
+	}
+
+	return src
+}
+
+func rgbaToNRGBA(src *image.RGBA) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(b)
+
+	for y := 0; y < b.Dy(); y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+b.Dx()*4]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()*4]
+
+		for i := 0; i < len(s); i += 4 {
+			a := s[i+3]
+			switch a {
+			case 0xff:
+				copy(d[i:i+4], s[i:i+4])
+			case 0:
+				// Fully transparent pixels carry no color once premultiplied.
+			default:
+				d[i+0] = uint8((uint32(s[i+0])*0xff + uint32(a)/2) / uint32(a))
+				d[i+1] = uint8((uint32(s[i+1])*0xff + uint32(a)/2) / uint32(a))
+				d[i+2] = uint8((uint32(s[i+2])*0xff + uint32(a)/2) / uint32(a))
+				d[i+3] = a
+			}
+		}
+	}
+
+	return dst
+}
+
+func nrgbaToRGBA(src *image.NRGBA) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+
+	for y := 0; y < b.Dy(); y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+b.Dx()*4]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()*4]
+
+		for i := 0; i < len(s); i += 4 {
+			a := uint32(s[i+3])
+			switch a {
+			case 0xff:
+				copy(d[i:i+4], s[i:i+4])
+			case 0:
+			default:
+				d[i+0] = uint8((uint32(s[i+0])*a + 0x7f) / 0xff)
+				d[i+1] = uint8((uint32(s[i+1])*a + 0x7f) / 0xff)
+				d[i+2] = uint8((uint32(s[i+2])*a + 0x7f) / 0xff)
+				d[i+3] = uint8(a)
+			}
+		}
+	}
+
+	return dst
+}
+
+func rgbaToGray(src *image.RGBA) *image.Gray {
+	b := src.Bounds()
+	dst := image.NewGray(b)
+
+	for y := 0; y < b.Dy(); y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+b.Dx()*4]
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()]
+
+		for x := range d {
+			// Same coefficients and rounding as color.GrayModel, on 8-bit input.
+			r := uint32(s[x*4+0]) * 0x101
+			g := uint32(s[x*4+1]) * 0x101
+			bl := uint32(s[x*4+2]) * 0x101
+			d[x] = uint8((19595*r + 38470*g + 7471*bl + 1<<15) >> 24)
+		}
+	}
+
+	return dst
+}
+
+func nrgbaToNYCbCrA(src *image.NRGBA) *image.NYCbCrA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewNYCbCrA(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+
+	for y := 0; y < h; y++ {
+		s := src.Pix[y*src.Stride : y*src.Stride+w*4]
+		for x := 0; x < w; x++ {
+			yy, _, _ := color.RGBToYCbCr(s[x*4+0], s[x*4+1], s[x*4+2])
+			dst.Y[y*dst.YStride+x] = yy
+			dst.A[y*dst.AStride+x] = s[x*4+3]
+		}
+	}
+
+	// Chroma is the average of each 2x2 block, clamped at the right and bottom edges.
+	for cy := 0; cy < (h+1)/2; cy++ {
+		for cx := 0; cx < (w+1)/2; cx++ {
+			var r, g, bl, n int
+
+			for y := 2 * cy; y < 2*cy+2 && y < h; y++ {
+				for x := 2 * cx; x < 2*cx+2 && x < w; x++ {
+					i := y*src.Stride + x*4
+					r += int(src.Pix[i+0])
+					g += int(src.Pix[i+1])
+					bl += int(src.Pix[i+2])
+					n++
+				}
+			}
+
+			_, cb, cr := color.RGBToYCbCr(uint8((r+n/2)/n), uint8((g+n/2)/n), uint8((bl+n/2)/n))
+			dst.Cb[cy*dst.CStride+cx] = cb
+			dst.Cr[cy*dst.CStride+cx] = cr
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,265 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// VP8X feature flags.
+const (
+	flagAnimation = 0x02
+	flagXMP       = 0x04
+	flagEXIF      = 0x08
+	flagAlpha     = 0x10
+	flagICCP      = 0x20
+)
+
+// chunk is a RIFF chunk of a WEBP file; data aliases the parsed input.
+type chunk struct {
+	fourcc string
+	offset int // offset of the chunk header in the file
+	data   []byte
+}
+
+// animFrame is an ANMF chunk: the frame rectangle, timing and the image chunks it carries.
+type animFrame struct {
+	x, y          int
+	width, height int
+	duration      int
+	blend         bool // alpha-blend with the previous canvas (otherwise overwrite)
+	dispose       bool // dispose to background after display (otherwise leave as is)
+	chunks        []chunk
+}
+
+// container is the parsed chunk layout of a WEBP file.
+type container struct {
+	chunks []chunk
+
+	flags  byte // VP8X flags, 0 for the simple format
+	width  int  // canvas width (VP8X) or bitstream width (simple format)
+	height int  // canvas height (VP8X) or bitstream height (simple format)
+
+	bgcolor   uint32 // ANIM background color, BGRA byte order
+	loopCount int
+
+	frames []animFrame // ANMF frames, nil for still images
+}
+
+// parseContainer parses the RIFF layout of data without decoding any bitstream.
+func parseContainer(data []byte) (*container, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("%w: not a RIFF WEBP file", ErrDecode)
+	}
+
+	riffSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	if riffSize < 4 || riffSize > len(data)-8 {
+		return nil, fmt.Errorf("%w: RIFF size %d exceeds data", ErrDecode, riffSize)
+	}
+
+	chunks, err := parseChunks(data[:8+riffSize], 12)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w: no chunks", ErrDecode)
+	}
+
+	c := &container{chunks: chunks}
+
+	switch chunks[0].fourcc {
+	case "VP8X":
+		if len(chunks[0].data) < 10 {
+			return nil, fmt.Errorf("%w: VP8X chunk too short", ErrDecode)
+		}
+
+		c.flags = chunks[0].data[0]
+		c.width = int(load24(chunks[0].data[4:])) + 1
+		c.height = int(load24(chunks[0].data[7:])) + 1
+	case "VP8 ", "VP8L":
+		w, h, err := bitstreamSize(chunks[0])
+		if err != nil {
+			return nil, err
+		}
+
+		c.width, c.height = w, h
+
+		return c, nil
+	default:
+		return nil, fmt.Errorf("%w: unexpected first chunk %q", ErrDecode, chunks[0].fourcc)
+	}
+
+	for _, ch := range chunks[1:] {
+		switch ch.fourcc {
+		case "ANIM":
+			if len(ch.data) < 6 {
+				return nil, fmt.Errorf("%w: ANIM chunk too short", ErrDecode)
+			}
+
+			c.bgcolor = binary.LittleEndian.Uint32(ch.data[0:4])
+			c.loopCount = int(binary.LittleEndian.Uint16(ch.data[4:6]))
+		case "ANMF":
+			f, err := parseFrame(ch)
+			if err != nil {
+				return nil, err
+			}
+
+			if f.x+f.width > c.width || f.y+f.height > c.height {
+				return nil, fmt.Errorf("%w: frame at %d,%d (%dx%d) exceeds canvas %dx%d", ErrDecode,
+					f.x, f.y, f.width, f.height, c.width, c.height)
+			}
+
+			c.frames = append(c.frames, f)
+		}
+	}
+
+	if c.flags&flagAnimation != 0 && len(c.frames) == 0 {
+		return nil, fmt.Errorf("%w: animation without frames", ErrDecode)
+	}
+
+	return c, nil
+}
+
+// parseChunks splits data[off:] into RIFF chunks, honouring the even-size padding. On error
+// it also returns the chunks before the broken one.
+func parseChunks(data []byte, off int) ([]chunk, error) {
+	var chunks []chunk
+
+	for off < len(data) {
+		if off+8 > len(data) {
+			return chunks, fmt.Errorf("%w: truncated chunk header at %d", ErrDecode, off)
+		}
+
+		fourcc := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		if size < 0 || size > len(data)-off-8 {
+			return chunks, fmt.Errorf("%w: chunk %q at %d: size %d exceeds data", ErrDecode, fourcc, off, size)
+		}
+
+		chunks = append(chunks, chunk{fourcc: fourcc, offset: off, data: data[off+8 : off+8+size : off+8+size]})
+
+		off += 8 + size + size&1
+	}
+
+	return chunks, nil
+}
+
+// parseFrame parses the ANMF header and the chunks of its frame data.
+func parseFrame(ch chunk) (animFrame, error) {
+	var f animFrame
+
+	if len(ch.data) < 16 {
+		return f, fmt.Errorf("%w: ANMF chunk too short", ErrDecode)
+	}
+
+	f.x = int(load24(ch.data[0:])) * 2
+	f.y = int(load24(ch.data[3:])) * 2
+	f.width = int(load24(ch.data[6:])) + 1
+	f.height = int(load24(ch.data[9:])) + 1
+	f.duration = int(load24(ch.data[12:]))
+	f.blend = ch.data[15]&0x02 == 0
+	f.dispose = ch.data[15]&0x01 != 0
+
+	chunks, err := parseChunks(ch.data, 16)
+	if err != nil {
+		return f, err
+	}
+
+	for i := range chunks {
+		chunks[i].offset += ch.offset + 8
+	}
+
+	f.chunks = chunks
+
+	return f, nil
+}
+
+// bitstream returns the frame as a standalone still WEBP file.
+func (f *animFrame) bitstream() []byte {
+	var alph, bs *chunk
+	for i := range f.chunks {
+		switch f.chunks[i].fourcc {
+		case "ALPH":
+			alph = &f.chunks[i]
+		case "VP8 ", "VP8L":
+			bs = &f.chunks[i]
+		}
+	}
+
+	if bs == nil {
+		return nil
+	}
+
+	var out []byte
+	if alph != nil && bs.fourcc == "VP8 " {
+		vp8x := make([]byte, 10)
+		vp8x[0] = flagAlpha
+		store24(vp8x[4:], uint32(f.width-1))
+		store24(vp8x[7:], uint32(f.height-1))
+
+		out = appendChunk(out, "VP8X", vp8x)
+		out = appendChunk(out, "ALPH", alph.data)
+	}
+
+	out = appendChunk(out, bs.fourcc, bs.data)
+
+	return riffWrap(out)
+}
+
+// bitstreamSize returns the dimensions stored in a VP8 or VP8L chunk.
+func bitstreamSize(ch chunk) (int, int, error) {
+	d := ch.data
+
+	switch ch.fourcc {
+	case "VP8 ":
+		// 3 bytes frame tag, 3 bytes start code, then 14-bit width and height.
+		if len(d) < 10 || d[3] != 0x9d || d[4] != 0x01 || d[5] != 0x2a {
+			return 0, 0, fmt.Errorf("%w: invalid VP8 header", ErrDecode)
+		}
+
+		return int(binary.LittleEndian.Uint16(d[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(d[8:]) & 0x3fff), nil
+	case "VP8L":
+		// Signature byte, then 14-bit width-1 and height-1.
+		if len(d) < 5 || d[0] != 0x2f {
+			return 0, 0, fmt.Errorf("%w: invalid VP8L header", ErrDecode)
+		}
+
+		bits := binary.LittleEndian.Uint32(d[1:])
+
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: %q is not a bitstream chunk", ErrDecode, ch.fourcc)
+}
+
+// appendChunk appends a RIFF chunk with the given payload, padded to an even size.
+func appendChunk(dst []byte, fourcc string, payload []byte) []byte {
+	dst = append(dst, fourcc...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = append(dst, payload...)
+	if len(payload)%2 == 1 {
+		dst = append(dst, 0)
+	}
+
+	return dst
+}
+
+// riffWrap prepends the RIFF/WEBP file header to the chunks.
+func riffWrap(chunks []byte) []byte {
+	out := make([]byte, 0, 12+len(chunks))
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(chunks)))
+	out = append(out, "WEBP"...)
+
+	return append(out, chunks...)
+}
+
+func load24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func store24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -0,0 +1,48 @@
+package webp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseContainer(t *testing.T) {
+	c, err := parseContainer(testWebpAnim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.flags&flagAnimation == 0 {
+		t.Error("animation flag not set")
+	}
+	if len(c.frames) != 17 {
+		t.Errorf("got %d frames, want %d", len(c.frames), 17)
+	}
+
+	for i, f := range c.frames {
+		if f.x+f.width > c.width || f.y+f.height > c.height {
+			t.Errorf("frame %d outside canvas", i)
+		}
+		if f.bitstream() == nil {
+			t.Errorf("frame %d has no bitstream", i)
+		}
+	}
+
+	c, err = parseContainer(testWebp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.width != 512 || c.height != 512 {
+		t.Errorf("got %dx%d, want 512x512", c.width, c.height)
+	}
+	if c.frames != nil {
+		t.Error("still image has frames")
+	}
+}
+
+func TestParseContainerTruncated(t *testing.T) {
+	for _, n := range []int{0, 11, 20, len(testWebpAnim) / 2} {
+		if _, err := parseContainer(testWebpAnim[:n]); !errors.Is(err, ErrDecode) {
+			t.Errorf("len %d: got %v, want ErrDecode", n, err)
+		}
+	}
+}
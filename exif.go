@@ -1,6 +1,7 @@
 package webp
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -44,7 +45,8 @@ type Exif struct {
 	Artist    string // Creator/photographer name.
 }
 
-// DecodeExif reads the EXIF metadata from a WEBP image. It returns ErrNoExif if the image carries no EXIF chunk.
+// DecodeExif reads the EXIF metadata from a WEBP image. It returns ErrNoExif if the image carries no EXIF chunk,
+// and an *ExifError if the EXIF data is malformed.
 func DecodeExif(r io.Reader) (*Exif, error) {
 	tiff := exifChunkReader(r)
 	if tiff == nil {
@@ -53,12 +55,151 @@ func DecodeExif(r io.Reader) (*Exif, error) {
 
 	exif := &Exif{Orientation: 1}
 	if err := parseExifData(tiff, exif); err != nil {
-		return nil, fmt.Errorf("webp: %w", err)
+		return nil, err
 	}
 
 	return exif, nil
 }
 
+// DecodeOrientedConfig is DecodeConfig for images decoded with Options{AutoRotate: true}: the width
+// and height are those of the displayed image, swapped for orientations 5-8. It also returns the raw
+// EXIF orientation, 1 when the image has no valid EXIF orientation. Only the chunk headers before the EXIF
+// chunk are read, the image data is skipped.
+func DecodeOrientedConfig(r io.Reader) (image.Config, int, error) {
+	hdr, err := io.ReadAll(io.LimitReader(r, webpMaxHeaderSize))
+	if err != nil {
+		return image.Config{}, 0, fmt.Errorf("webp: read: %w", err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(hdr))
+	if err != nil {
+		return image.Config{}, 0, err
+	}
+
+	orientation := 1
+	if tiff := exifChunkReader(io.MultiReader(bytes.NewReader(hdr), r)); tiff != nil {
+		// IFD0 is read first, so a malformed sub-IFD does not lose the orientation.
+		exif := &Exif{Orientation: 1}
+		parseExifData(tiff, exif)
+		if exif.Orientation >= 1 && exif.Orientation <= 8 {
+			orientation = exif.Orientation
+		}
+	}
+
+	if orientation >= 5 && orientation <= 8 {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+
+	return cfg, orientation, nil
+}
+
+// SetExifOrientation returns a copy of the TIFF/EXIF payload exif with its Orientation tag set to
+// orientation (1-8), adding the tag to IFD0 when absent; an empty exif yields a payload holding only
+// the tag. A JPEG-style "Exif\0\0" prefix is kept. Use it to keep the EXIF of a source image in step
+// with pixels that were rotated, e.g. by Decode with AutoRotate.
+func SetExifOrientation(exif []byte, orientation int) ([]byte, error) {
+	if orientation < 1 || orientation > 8 {
+		return nil, fmt.Errorf("webp: invalid EXIF orientation %d", orientation)
+	}
+
+	if len(exif) == 0 {
+		exif = []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00") // header and an empty IFD0
+	}
+
+	var prefix []byte
+	if len(exif) >= 6 && string(exif[0:4]) == "Exif" && exif[4] == 0 && exif[5] == 0 {
+		prefix, exif = exif[:6], exif[6:]
+	}
+
+	if len(exif) < 8 {
+		return nil, &ExifError{Offset: 0, Reason: "data too short"}
+	}
+
+	var order binary.ByteOrder
+	switch string(exif[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, &ExifError{Offset: 0, Reason: "invalid byte order marker"}
+	}
+
+	if order.Uint16(exif[2:]) != 42 {
+		return nil, &ExifError{Offset: 2, Reason: "invalid magic number"}
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return nil, &ExifError{Offset: 4, Reason: "IFD offset out of bounds"}
+	}
+
+	n := int(order.Uint16(exif[ifd:]))
+	end := ifd + 2 + 12*n
+	if end > len(exif) {
+		return nil, &ExifError{Offset: ifd, Reason: fmt.Sprintf("%d IFD entries exceed the data", n)}
+	}
+
+	tiff := append([]byte(nil), exif...)
+
+	// A SHORT value is stored left-justified in the 4-byte value field, in either byte order.
+	putEntry := func(e []byte) {
+		order.PutUint16(e[0:], tagOrientation)
+		order.PutUint16(e[2:], typeUnsignedShort)
+		order.PutUint32(e[4:], 1)
+		order.PutUint16(e[8:], uint16(orientation))
+		order.PutUint16(e[10:], 0)
+	}
+
+	for i := 0; i < n; i++ {
+		e := tiff[ifd+2+12*i:]
+		if order.Uint16(e) == tagOrientation {
+			putEntry(e)
+			return append(prefix[:len(prefix):len(prefix)], tiff...), nil
+		}
+	}
+
+	if n == 0xffff {
+		return nil, &ExifError{Offset: ifd, Reason: "IFD0 is full"}
+	}
+
+	// Write a new IFD0 with the tag after the data, so every offset into the data stays valid.
+	if len(tiff)%2 == 1 {
+		tiff = append(tiff, 0) // IFDs start on a word boundary
+	}
+
+	next := uint32(0)
+	if end+4 <= len(exif) {
+		next = order.Uint32(exif[end:])
+	}
+
+	off := len(tiff)
+	tiff = append(tiff, make([]byte, 2+12*(n+1)+4)...)
+	order.PutUint16(tiff[off:], uint16(n+1))
+
+	j, inserted := off+2, false
+	for i := 0; i < n; i++ {
+		e := exif[ifd+2+12*i : ifd+2+12*i+12]
+		if !inserted && order.Uint16(e) > tagOrientation {
+			putEntry(tiff[j:])
+			j, inserted = j+12, true
+		}
+
+		copy(tiff[j:], e)
+		j += 12
+	}
+
+	if !inserted {
+		putEntry(tiff[j:])
+		j += 12
+	}
+
+	order.PutUint32(tiff[j:], next)
+	order.PutUint32(tiff[4:], uint32(off))
+
+	return append(prefix[:len(prefix):len(prefix)], tiff...), nil
+}
+
 // exifChunkReader streams the RIFF chunks, discarding chunk bodies until the EXIF payload is found.
 func exifChunkReader(r io.Reader) []byte {
 	var hdr [12]byte
@@ -79,8 +220,9 @@ func exifChunkReader(r io.Reader) []byte {
 		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
 
 		if fourcc == "EXIF" {
-			payload := make([]byte, size)
-			if _, err := io.ReadFull(r, payload); err != nil {
+			// The buffer grows with the data read rather than the size the header claims.
+			payload, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil || int64(len(payload)) != size {
 				return nil
 			}
 			// Some encoders prefix the chunk with the JPEG-style "Exif\0\0" header.
@@ -139,75 +281,16 @@ func exifOrientation(data []byte) int {
 		return 1
 	}
 
+	// IFD0 is read first, so a malformed sub-IFD does not lose the orientation.
 	exif := &Exif{Orientation: 1}
-	if parseExifData(tiff, exif) != nil || exif.Orientation < 1 || exif.Orientation > 8 {
+	parseExifData(tiff, exif)
+	if exif.Orientation < 1 || exif.Orientation > 8 {
 		return 1
 	}
 
 	return exif.Orientation
 }
 
-// orientTarget maps a source pixel to its destination for the given EXIF orientation.
-func orientTarget(orientation, sx, sy, sw, sh int) (int, int) {
-	switch orientation {
-	case 2: // flip horizontal
-		return sw - 1 - sx, sy
-	case 3: // rotate 180
-		return sw - 1 - sx, sh - 1 - sy
-	case 4: // flip vertical
-		return sx, sh - 1 - sy
-	case 5: // transpose
-		return sy, sx
-	case 6: // rotate 90 CW
-		return sh - 1 - sy, sx
-	case 7: // transverse
-		return sh - 1 - sy, sw - 1 - sx
-	case 8: // rotate 270 CW
-		return sy, sw - 1 - sx
-	}
-
-	return sx, sy
-}
-
-// applyOrientation returns img rotated/flipped per the EXIF orientation (unchanged for 1 or out of range).
-func applyOrientation(img image.Image, orientation int) image.Image {
-	if orientation <= 1 || orientation > 8 {
-		return img
-	}
-
-	b := img.Bounds()
-	sw, sh := b.Dx(), b.Dy()
-	dw, dh := sw, sh
-	if orientation >= 5 {
-		dw, dh = sh, sw // 90/270 rotations swap width and height
-	}
-
-	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
-
-	if src, ok := img.(*image.RGBA); ok {
-		for sy := 0; sy < sh; sy++ {
-			srow := src.Pix[(b.Min.Y+sy)*src.Stride+b.Min.X*4:]
-			for sx := 0; sx < sw; sx++ {
-				dx, dy := orientTarget(orientation, sx, sy, sw, sh)
-				si := sx * 4
-				di := dy*dst.Stride + dx*4
-				copy(dst.Pix[di:di+4], srow[si:si+4])
-			}
-		}
-
-		return dst
-	}
-
-	for sy := 0; sy < sh; sy++ {
-		for sx := 0; sx < sw; sx++ {
-			dx, dy := orientTarget(orientation, sx, sy, sw, sh)
-			dst.Set(dx, dy, img.At(b.Min.X+sx, b.Min.Y+sy))
-		}
-	}
-
-	return dst
-}
-
 // EXIF tag constants
 const (
 	// Main IFD tags
@@ -223,6 +306,9 @@ const (
 	tagExifIFDPointer = 0x8769
 	tagGPSIFDPointer  = 0x8825
 
+	// EXIF SubIFD pointer to the Interoperability IFD
+	tagInteropIFDPointer = 0xA005
+
 	// EXIF SubIFD tags
 	tagExposureTime     = 0x829A
 	tagFNumber          = 0x829D
@@ -254,312 +340,385 @@ const (
 	typeSignedRational   = 10
 	typeSingleFloat      = 11
 	typeDoubleFloat      = 12
+	typeIFD              = 13
 )
 
-// exifReader wraps the EXIF data with helper functions for reading different data types
-type exifReader struct {
-	data         []byte
-	littleEndian bool
+// Limits of the EXIF parser, far above what cameras write, so malicious data cannot make it loop
+// or spend unbounded time.
+const (
+	maxExifIFDs    = 32   // IFDs visited, including the IFD0 chain
+	maxExifDepth   = 4    // nesting of sub-IFDs
+	maxExifEntries = 8192 // entries over all IFDs
+)
+
+// ErrInvalidExif is wrapped by the errors for malformed EXIF data, see ExifError.
+var ErrInvalidExif = errors.New("webp: invalid exif data")
+
+// ExifError describes malformed EXIF data. It wraps ErrInvalidExif.
+type ExifError struct {
+	Offset int    // Offset in the TIFF data of the malformed structure.
+	Reason string // What is malformed.
 }
 
-func (r *exifReader) uint16(offset int) uint16 {
-	if offset+1 >= len(r.data) {
-		return 0
-	}
-	if r.littleEndian {
-		return uint16(r.data[offset]) | (uint16(r.data[offset+1]) << 8)
-	}
-	return (uint16(r.data[offset]) << 8) | uint16(r.data[offset+1])
+func (e *ExifError) Error() string {
+	return fmt.Sprintf("webp: invalid exif data at offset %d: %s", e.Offset, e.Reason)
 }
 
-func (r *exifReader) uint32(offset int) uint32 {
-	if offset+3 >= len(r.data) {
-		return 0
-	}
-	if r.littleEndian {
-		return uint32(r.data[offset]) | (uint32(r.data[offset+1]) << 8) |
-			(uint32(r.data[offset+2]) << 16) | (uint32(r.data[offset+3]) << 24)
-	}
-	return (uint32(r.data[offset]) << 24) | (uint32(r.data[offset+1]) << 16) |
-		(uint32(r.data[offset+2]) << 8) | uint32(r.data[offset+3])
+func (e *ExifError) Unwrap() error {
+	return ErrInvalidExif
 }
 
-func (r *exifReader) readString(offset, maxLen int) string {
-	if offset >= len(r.data) {
-		return ""
-	}
-	end := offset
-	for end < len(r.data) && end < offset+maxLen && r.data[end] != 0 {
-		end++
-	}
-	return string(r.data[offset:end])
+// ifdKind selects the tags read from an IFD.
+type ifdKind int
+
+const (
+	ifdMain      ifdKind = iota // IFD0
+	ifdThumbnail                // IFD1 and later IFDs of the IFD0 chain
+	ifdExif                     // EXIF SubIFD
+	ifdGPS                      // GPS SubIFD
+	ifdInterop                  // Interoperability SubIFD
+)
+
+// subIFD is a sub-IFD pointed to by an IFD entry.
+type subIFD struct {
+	offset int64
+	kind   ifdKind
 }
 
-func (r *exifReader) readRational(offset int) float64 {
-	if offset+7 >= len(r.data) {
-		return 0
-	}
-	numerator := r.uint32(offset)
-	denominator := r.uint32(offset + 4)
-	if denominator == 0 {
-		return 0
-	}
-	return float64(numerator) / float64(denominator)
+// exifEntry is an IFD entry; value holds exactly the bytes of its data.
+type exifEntry struct {
+	tag      uint16
+	dataType uint16
+	count    uint32
+	value    []byte
+}
+
+// exifReader reads IFDs from the TIFF data, bounding every offset by the data and the
+// number of IFDs and entries by the limits above.
+type exifReader struct {
+	data    []byte
+	order   binary.ByteOrder
+	visited map[int]bool
+	entries int
 }
 
-// parseExifData parses the TIFF/EXIF data structure and populates the Exif struct
+// parseExifData parses the TIFF/EXIF data structure and populates the Exif struct. Values of
+// the IFDs read before a malformed structure is found are kept.
 func parseExifData(data []byte, exif *Exif) error {
 	if len(data) < 8 {
-		return fmt.Errorf("EXIF data too short")
+		return &ExifError{Offset: 0, Reason: "data too short"}
 	}
 
-	reader := &exifReader{data: data}
+	reader := &exifReader{data: data, visited: make(map[int]bool)}
 
 	// Check byte order
-	if data[0] == 0x49 && data[1] == 0x49 {
-		reader.littleEndian = true // Intel (little-endian)
-	} else if data[0] == 0x4D && data[1] == 0x4D {
-		reader.littleEndian = false // Motorola (big-endian)
-	} else {
-		return fmt.Errorf("invalid EXIF byte order marker")
+	switch string(data[0:2]) {
+	case "II":
+		reader.order = binary.LittleEndian // Intel
+	case "MM":
+		reader.order = binary.BigEndian // Motorola
+	default:
+		return &ExifError{Offset: 0, Reason: "invalid byte order marker"}
 	}
 
 	// Check magic number (42)
-	if reader.uint16(2) != 42 {
-		return fmt.Errorf("invalid EXIF magic number")
+	if reader.order.Uint16(data[2:]) != 42 {
+		return &ExifError{Offset: 2, Reason: "invalid magic number"}
 	}
 
-	// Get offset to first IFD
-	ifdOffset := reader.uint32(4)
-	if ifdOffset < 8 || int(ifdOffset) >= len(data) {
-		return fmt.Errorf("invalid IFD offset")
-	}
+	return reader.walk(int64(reader.order.Uint32(data[4:])), ifdMain, 0, exif)
+}
 
-	// Parse main IFD (IFD0)
-	exifIFDOffset, gpsIFDOffset := parseIFD(reader, int(ifdOffset), exif)
+// walk parses the IFD at offset and the sub-IFDs it points to; for IFD0 it follows the chain of
+// next IFDs too.
+func (r *exifReader) walk(offset int64, kind ifdKind, depth int, exif *Exif) error {
+	for offset != 0 {
+		if depth > maxExifDepth {
+			return &ExifError{Offset: int(offset), Reason: "IFDs nested too deep"}
+		}
 
-	// Parse EXIF SubIFD if present
-	if exifIFDOffset > 0 {
-		parseExifSubIFD(reader, exifIFDOffset, exif)
-	}
+		entries, next, err := r.readIFD(offset)
+		if err != nil {
+			return err
+		}
+
+		var subs []subIFD
+		addSub := func(e exifEntry, kind ifdKind) {
+			if e.dataType == typeUnsignedLong || e.dataType == typeIFD {
+				if v, ok := e.uint32(r.order); ok {
+					subs = append(subs, subIFD{int64(v), kind})
+				}
+			}
+		}
+
+		switch kind {
+		case ifdMain:
+			for _, e := range entries {
+				switch e.tag {
+				case tagExifIFDPointer:
+					addSub(e, ifdExif)
+				case tagGPSIFDPointer:
+					addSub(e, ifdGPS)
+				default:
+					parseMainEntry(e, r.order, exif)
+				}
+			}
+		case ifdExif:
+			for _, e := range entries {
+				if e.tag == tagInteropIFDPointer {
+					addSub(e, ifdInterop)
+				} else {
+					parseExifEntry(e, r.order, exif)
+				}
+			}
+		case ifdGPS:
+			parseGPSEntries(entries, r.order, exif)
+		}
+
+		for _, sub := range subs {
+			if sub.offset == 0 {
+				continue
+			}
+			if err := r.walk(sub.offset, sub.kind, depth+1, exif); err != nil {
+				return err
+			}
+		}
+
+		// Only the IFD0 chain (IFD0, the thumbnail IFD1, ...) is linked; a sub-IFD's link is not used.
+		if kind != ifdMain && kind != ifdThumbnail {
+			return nil
+		}
 
-	// Parse GPS SubIFD if present
-	if gpsIFDOffset > 0 {
-		parseGPSSubIFD(reader, gpsIFDOffset, exif)
+		offset, kind = next, ifdThumbnail
 	}
 
 	return nil
 }
 
-// parseIFD parses an Image File Directory and returns pointers to EXIF and GPS SubIFDs
-func parseIFD(reader *exifReader, offset int, exif *Exif) (exifIFDOffset, gpsIFDOffset int) {
-	if offset+1 >= len(reader.data) {
-		return 0, 0
+// readIFD returns the entries of the IFD at offset and the offset of the next IFD (0 for none).
+func (r *exifReader) readIFD(offset int64) ([]exifEntry, int64, error) {
+	if offset < 8 || offset > int64(len(r.data))-2 {
+		return nil, 0, &ExifError{Offset: int(min(offset, int64(len(r.data)))), Reason: "IFD offset out of bounds"}
+	}
+
+	off := int(offset)
+	if r.visited[off] {
+		return nil, 0, &ExifError{Offset: off, Reason: "IFD cycle"}
+	}
+	if len(r.visited) >= maxExifIFDs {
+		return nil, 0, &ExifError{Offset: off, Reason: "too many IFDs"}
+	}
+	r.visited[off] = true
+
+	n := int(r.order.Uint16(r.data[off:]))
+	end := off + 2 + 12*n
+	if end > len(r.data) {
+		return nil, 0, &ExifError{Offset: off, Reason: fmt.Sprintf("%d IFD entries exceed the data", n)}
 	}
 
-	numEntries := reader.uint16(offset)
-	offset += 2
+	r.entries += n
+	if r.entries > maxExifEntries {
+		return nil, 0, &ExifError{Offset: off, Reason: "too many IFD entries"}
+	}
 
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
+	entries := make([]exifEntry, 0, n)
+	for i := 0; i < n; i++ {
+		b := r.data[off+2+12*i : off+2+12*i+12]
+
+		e := exifEntry{
+			tag:      r.order.Uint16(b[0:]),
+			dataType: r.order.Uint16(b[2:]),
+			count:    r.order.Uint32(b[4:]),
 		}
 
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
+		// Entries of unknown types or with values outside the data are skipped; the IFD
+		// structure itself is intact, so the other entries are still read.
+		size, ok := getDataSize(e.dataType, e.count)
+		if !ok {
+			continue
+		}
 
-		// For values > 4 bytes, the value field contains an offset
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
+		if size <= 4 {
+			e.value = b[8 : 8+size]
+		} else {
+			v := int64(r.order.Uint32(b[8:]))
+			if v+size > int64(len(r.data)) {
 				continue
 			}
+			e.value = r.data[v : v+size]
 		}
 
-		switch tag {
-		case tagOrientation:
-			if dataType == typeUnsignedShort {
-				exif.Orientation = int(reader.uint16(valueOffset))
-			}
-		case tagImageWidth:
-			if dataType == typeUnsignedShort {
-				exif.Width = int(reader.uint16(valueOffset))
-			} else if dataType == typeUnsignedLong {
-				exif.Width = int(reader.uint32(valueOffset))
-			}
-		case tagImageLength:
-			if dataType == typeUnsignedShort {
-				exif.Height = int(reader.uint16(valueOffset))
-			} else if dataType == typeUnsignedLong {
-				exif.Height = int(reader.uint32(valueOffset))
-			}
-		case tagMake:
-			if dataType == typeASCIIString {
-				exif.Make = reader.readString(valueOffset, int(count))
-			}
-		case tagModel:
-			if dataType == typeASCIIString {
-				exif.Model = reader.readString(valueOffset, int(count))
-			}
-		case tagSoftware:
-			if dataType == typeASCIIString {
-				exif.Software = reader.readString(valueOffset, int(count))
-			}
-		case tagDateTime:
-			if dataType == typeASCIIString {
-				exif.DateTime = reader.readString(valueOffset, int(count))
-			}
-		case tagArtist:
-			if dataType == typeASCIIString {
-				exif.Artist = reader.readString(valueOffset, int(count))
-			}
-		case tagCopyright:
-			if dataType == typeASCIIString {
-				exif.Copyright = reader.readString(valueOffset, int(count))
-			}
-		case tagExifIFDPointer:
-			if dataType == typeUnsignedLong {
-				exifIFDOffset = int(reader.uint32(valueOffset))
-			}
-		case tagGPSIFDPointer:
-			if dataType == typeUnsignedLong {
-				gpsIFDOffset = int(reader.uint32(valueOffset))
-			}
-		}
+		entries = append(entries, e)
+	}
+
+	// The link to the next IFD is optional at the end of the data.
+	var next int64
+	if end+4 <= len(r.data) {
+		next = int64(r.order.Uint32(r.data[end:]))
 	}
 
-	return exifIFDOffset, gpsIFDOffset
+	return entries, next, nil
 }
 
-// parseExifSubIFD parses the EXIF SubIFD for camera settings
-func parseExifSubIFD(reader *exifReader, offset int, exif *Exif) {
-	if offset+1 >= len(reader.data) {
-		return
+// uint16 returns the first value of an unsigned short entry.
+func (e exifEntry) uint16(order binary.ByteOrder) (uint16, bool) {
+	if e.dataType != typeUnsignedShort || len(e.value) < 2 {
+		return 0, false
 	}
 
-	numEntries := reader.uint16(offset)
-	offset += 2
+	return order.Uint16(e.value), true
+}
 
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
-		}
+// uint32 returns the first value of an unsigned short or long entry.
+func (e exifEntry) uint32(order binary.ByteOrder) (uint32, bool) {
+	if v, ok := e.uint16(order); ok {
+		return uint32(v), true
+	}
 
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
+	if (e.dataType != typeUnsignedLong && e.dataType != typeIFD) || len(e.value) < 4 {
+		return 0, false
+	}
 
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
-				continue
-			}
+	return order.Uint32(e.value), true
+}
+
+// string returns an ASCII entry up to its first NUL.
+func (e exifEntry) string() (string, bool) {
+	if e.dataType != typeASCIIString {
+		return "", false
+	}
+
+	v := e.value
+	if i := bytes.IndexByte(v, 0); i >= 0 {
+		v = v[:i]
+	}
+
+	return string(v), true
+}
+
+// rational returns the i-th value of an unsigned rational entry, 0 for a zero denominator.
+func (e exifEntry) rational(order binary.ByteOrder, i int) (float64, bool) {
+	if e.dataType != typeUnsignedRational || len(e.value) < 8*(i+1) {
+		return 0, false
+	}
+
+	num := order.Uint32(e.value[8*i:])
+	den := order.Uint32(e.value[8*i+4:])
+	if den == 0 {
+		return 0, true
+	}
+
+	return float64(num) / float64(den), true
+}
+
+// parseMainEntry reads an IFD0 entry.
+func parseMainEntry(e exifEntry, order binary.ByteOrder, exif *Exif) {
+	setString := func(dst *string) {
+		if v, ok := e.string(); ok {
+			*dst = v
 		}
+	}
 
-		switch tag {
-		case tagExposureTime:
-			if dataType == typeUnsignedRational {
-				exif.ExposureTime = reader.readRational(valueOffset)
-			}
-		case tagFNumber:
-			if dataType == typeUnsignedRational {
-				exif.FNumber = reader.readRational(valueOffset)
-			}
-		case tagISOSpeedRatings:
-			if dataType == typeUnsignedShort {
-				exif.ISOSpeed = int(reader.uint16(valueOffset))
-			}
-		case tagDateTimeOriginal:
-			if dataType == typeASCIIString {
-				exif.DateTimeOriginal = reader.readString(valueOffset, int(count))
-			}
-		case tagFlash:
-			if dataType == typeUnsignedShort {
-				exif.Flash = int(reader.uint16(valueOffset))
-			}
-		case tagFocalLength:
-			if dataType == typeUnsignedRational {
-				exif.FocalLength = reader.readRational(valueOffset)
-			}
+	switch e.tag {
+	case tagOrientation:
+		if v, ok := e.uint16(order); ok {
+			exif.Orientation = int(v)
+		}
+	case tagImageWidth:
+		if v, ok := e.uint32(order); ok {
+			exif.Width = int(v)
 		}
+	case tagImageLength:
+		if v, ok := e.uint32(order); ok {
+			exif.Height = int(v)
+		}
+	case tagMake:
+		setString(&exif.Make)
+	case tagModel:
+		setString(&exif.Model)
+	case tagSoftware:
+		setString(&exif.Software)
+	case tagDateTime:
+		setString(&exif.DateTime)
+	case tagArtist:
+		setString(&exif.Artist)
+	case tagCopyright:
+		setString(&exif.Copyright)
 	}
 }
 
-// parseGPSSubIFD parses the GPS SubIFD for location data
-func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
-	if offset+1 >= len(reader.data) {
-		return
+// parseExifEntry reads an EXIF SubIFD entry for camera settings.
+func parseExifEntry(e exifEntry, order binary.ByteOrder, exif *Exif) {
+	switch e.tag {
+	case tagExposureTime:
+		if v, ok := e.rational(order, 0); ok {
+			exif.ExposureTime = v
+		}
+	case tagFNumber:
+		if v, ok := e.rational(order, 0); ok {
+			exif.FNumber = v
+		}
+	case tagISOSpeedRatings:
+		if v, ok := e.uint16(order); ok {
+			exif.ISOSpeed = int(v)
+		}
+	case tagDateTimeOriginal:
+		if v, ok := e.string(); ok {
+			exif.DateTimeOriginal = v
+		}
+	case tagFlash:
+		if v, ok := e.uint16(order); ok {
+			exif.Flash = int(v)
+		}
+	case tagFocalLength:
+		if v, ok := e.rational(order, 0); ok {
+			exif.FocalLength = v
+		}
 	}
+}
 
-	numEntries := reader.uint16(offset)
-	offset += 2
-
+// parseGPSEntries reads the GPS SubIFD for location data.
+func parseGPSEntries(entries []exifEntry, order binary.ByteOrder, exif *Exif) {
 	var latRef, lonRef string
 	var latValues, lonValues []float64
+	var alt float64
+	var altRef byte
+	hasAlt := false
 
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
+	degrees := func(e exifEntry) []float64 {
+		if e.count != 3 {
+			return nil
 		}
 
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
-
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
-				continue
+		var v [3]float64
+		for i := range v {
+			r, ok := e.rational(order, i)
+			if !ok {
+				return nil
 			}
+			v[i] = r
 		}
 
-		switch tag {
+		return v[:]
+	}
+
+	for _, e := range entries {
+		switch e.tag {
 		case tagGPSLatitudeRef:
-			if dataType == typeASCIIString {
-				latRef = reader.readString(valueOffset, 2)
-			}
+			latRef, _ = e.string()
 		case tagGPSLatitude:
-			if dataType == typeUnsignedRational && count == 3 {
-				latValues = []float64{
-					reader.readRational(valueOffset),
-					reader.readRational(valueOffset + 8),
-					reader.readRational(valueOffset + 16),
-				}
-			}
+			latValues = degrees(e)
 		case tagGPSLongitudeRef:
-			if dataType == typeASCIIString {
-				lonRef = reader.readString(valueOffset, 2)
-			}
+			lonRef, _ = e.string()
 		case tagGPSLongitude:
-			if dataType == typeUnsignedRational && count == 3 {
-				lonValues = []float64{
-					reader.readRational(valueOffset),
-					reader.readRational(valueOffset + 8),
-					reader.readRational(valueOffset + 16),
-				}
+			lonValues = degrees(e)
+		case tagGPSAltitudeRef:
+			// 0 = above sea level, 1 = below
+			if e.dataType == typeUnsignedByte && len(e.value) > 0 {
+				altRef = e.value[0]
 			}
 		case tagGPSAltitude:
-			if dataType == typeUnsignedRational {
-				alt := reader.readRational(valueOffset)
-				// Check altitude reference (0 = above sea level, 1 = below)
-				if entryOffset+12 < len(reader.data) {
-					altRef := reader.data[valueOffset-4] // Previous tag should be altitude ref
-					if altRef == 1 {
-						alt = -alt
-					}
-				}
-				exif.GPSAltitude = alt
-			}
+			alt, hasAlt = e.rational(order, 0)
 		}
 	}
 
@@ -576,22 +735,29 @@ func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
 			exif.GPSLongitude = -exif.GPSLongitude
 		}
 	}
+	if hasAlt {
+		if altRef == 1 {
+			alt = -alt
+		}
+		exif.GPSAltitude = alt
+	}
 }
 
-// getDataSize calculates the size in bytes for a given EXIF data type and count
-func getDataSize(dataType uint16, count uint32) int {
-	var componentSize int
+// getDataSize calculates the size in bytes for a given EXIF data type and count. It reports
+// false for unknown types; the 64-bit product of a uint32 count cannot overflow.
+func getDataSize(dataType uint16, count uint32) (int64, bool) {
+	var componentSize int64
 	switch dataType {
 	case typeUnsignedByte, typeSignedByte, typeASCIIString, typeUndefined:
 		componentSize = 1
 	case typeUnsignedShort, typeSignedShort:
 		componentSize = 2
-	case typeUnsignedLong, typeSignedLong, typeSingleFloat:
+	case typeUnsignedLong, typeSignedLong, typeSingleFloat, typeIFD:
 		componentSize = 4
 	case typeUnsignedRational, typeSignedRational, typeDoubleFloat:
 		componentSize = 8
 	default:
-		componentSize = 1
+		return 0, false
 	}
-	return componentSize * int(count)
+	return componentSize * int64(count), true
 }
@@ -2,7 +2,11 @@ package webp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -65,3 +69,340 @@ func TestDecodeAutoRotate(t *testing.T) {
 		t.Errorf("auto-rotated decode = %dx%d, want 256x512", b.Dx(), b.Dy())
 	}
 }
+
+func TestDecodeOrientedConfig(t *testing.T) {
+	data, err := os.ReadFile("testdata/exif.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, orientation, err := DecodeOrientedConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation != 6 {
+		t.Errorf("orientation = %d, want 6", orientation)
+	}
+
+	// The config matches the auto-rotated image.
+	rot, err := Decode(bytes.NewReader(data), Options{AutoRotate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := rot.Bounds(); cfg.Width != b.Dx() || cfg.Height != b.Dy() {
+		t.Errorf("config = %dx%d, want %dx%d", cfg.Width, cfg.Height, b.Dx(), b.Dy())
+	}
+
+	data, err = os.ReadFile("testdata/test.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, orientation, err = DecodeOrientedConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation != 1 || cfg != plain {
+		t.Errorf("got %+v orientation %d, want %+v orientation 1", cfg, orientation, plain)
+	}
+
+	// Out of range orientations read as 1, as in Decode with AutoRotate.
+	for _, o := range []byte{0, 9} {
+		tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+		tiff[18] = o
+
+		var buf bytes.Buffer
+		if err := SetMetadata(&buf, bytes.NewReader(data), Metadata{EXIF: tiff}); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, orientation, err = DecodeOrientedConfig(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orientation != 1 || cfg.Width != plain.Width || cfg.Height != plain.Height {
+			t.Errorf("orientation %d: got %dx%d orientation %d", o, cfg.Width, cfg.Height, orientation)
+		}
+	}
+}
+
+func TestSetExifOrientation(t *testing.T) {
+	// IFD0 with Make, Orientation 6 and Software, little-endian.
+	withTag := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	// IFD0 with Make only, big-endian, the string stored after the IFD.
+	withoutTag := []byte("MM\x00*\x00\x00\x00\x08\x00\x01\x01\x0f\x00\x02\x00\x00\x00\x07\x00\x00\x00\x1a\x00\x00\x00\x00Camera\x00")
+
+	for _, tc := range []struct {
+		name string
+		exif []byte
+		make string
+	}{
+		{"replace", withTag, ""},
+		{"insert", withoutTag, "Camera"},
+		{"prefix", append([]byte("Exif\x00\x00"), withoutTag...), "Camera"},
+		{"empty", nil, ""},
+	} {
+		for _, o := range []int{1, 8} {
+			out, err := SetExifOrientation(tc.exif, o)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+
+			if bytes.HasPrefix(tc.exif, []byte("Exif")) != bytes.HasPrefix(out, []byte("Exif")) {
+				t.Errorf("%s: prefix not kept", tc.name)
+			}
+
+			ex := &Exif{}
+			if err := parseExifData(bytes.TrimPrefix(out, []byte("Exif\x00\x00")), ex); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if ex.Orientation != o || ex.Make != tc.make {
+				t.Errorf("%s: got orientation %d make %q, want %d %q", tc.name, ex.Orientation, ex.Make, o, tc.make)
+			}
+		}
+	}
+
+	if _, err := SetExifOrientation(withTag, 9); err == nil {
+		t.Error("invalid orientation accepted")
+	}
+	if _, err := SetExifOrientation([]byte("XX*\x00\x08\x00\x00\x00"), 1); err == nil {
+		t.Error("invalid byte order accepted")
+	}
+}
+
+func TestEncodeOrientation(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i * 5)
+		if i%4 == 3 {
+			src.Pix[i] = 255 // opaque, the wasm decoder premultiplies
+		}
+	}
+
+	source := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+
+	for _, tc := range []struct {
+		name        string
+		opt         Options
+		orientation int
+		size        image.Point
+	}{
+		{"bake", Options{Lossless: true, Exact: true, Orientation: 6, ExifData: source}, 1, image.Pt(4, 6)},
+		{"tag", Options{Lossless: true, Exact: true, Orientation: 6, TagOrientation: true}, 6, image.Pt(6, 4)},
+	} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, tc.opt); err != nil {
+			t.Fatal(err)
+		}
+
+		ex, err := DecodeExif(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if ex.Orientation != tc.orientation {
+			t.Errorf("%s: orientation %d, want %d", tc.name, ex.Orientation, tc.orientation)
+		}
+
+		img, err := Decode(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Size() != tc.size {
+			t.Errorf("%s: size %v, want %v", tc.name, img.Bounds().Size(), tc.size)
+		}
+
+		// Either way the displayed image is the rotated source.
+		shown, err := Decode(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, AutoRotate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(shown.(*image.NRGBA).Pix, Rotate90(src).(*image.NRGBA).Pix) {
+			t.Errorf("%s: displayed image differs", tc.name)
+		}
+	}
+
+	anim := &WEBP{Image: []image.Image{src, FlipVertical(src)}, Delay: []int{100, 100}}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, Options{Lossless: true, Exact: true, Orientation: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := got.Image[0].Bounds(); b.Dx() != 4 || b.Dy() != 6 {
+		t.Errorf("animation canvas %dx%d, want 4x6", b.Dx(), b.Dy())
+	}
+	if !bytes.Equal(got.Image[1].(*image.NRGBA).Pix, Rotate270(FlipVertical(src)).(*image.NRGBA).Pix) {
+		t.Error("animation frame not rotated")
+	}
+	if ex, err := DecodeExif(bytes.NewReader(buf.Bytes())); err != nil || ex.Orientation != 1 {
+		t.Errorf("animation EXIF %+v, %v", ex, err)
+	}
+}
+
+// tiffEntry is an IFD entry with an inline value.
+type tiffEntry struct {
+	tag, dataType uint16
+	count, value  uint32
+}
+
+// littleTIFF returns little-endian TIFF data with an IFD0 of entries at offset 8, linked to
+// next, followed by extra.
+func littleTIFF(entries []tiffEntry, next uint32, extra ...byte) []byte {
+	b := littleIFD([]byte("II*\x00\x08\x00\x00\x00"), entries, next)
+
+	return append(b, extra...)
+}
+
+// littleIFD appends a little-endian IFD to b.
+func littleIFD(b []byte, entries []tiffEntry, next uint32) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(entries)))
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint16(b, e.tag)
+		b = binary.LittleEndian.AppendUint16(b, e.dataType)
+		b = binary.LittleEndian.AppendUint32(b, e.count)
+		b = binary.LittleEndian.AppendUint32(b, e.value)
+	}
+
+	return binary.LittleEndian.AppendUint32(b, next)
+}
+
+func TestParseExifMalformed(t *testing.T) {
+	orientation := tiffEntry{tagOrientation, typeUnsignedShort, 1, 6}
+
+	// A chain of empty IFDs longer than the IFD limit.
+	chain := []byte("II*\x00\x08\x00\x00\x00")
+	for i := 0; i < maxExifIFDs+1; i++ {
+		chain = binary.LittleEndian.AppendUint16(chain, 0)
+		chain = binary.LittleEndian.AppendUint32(chain, uint32(len(chain)+4))
+	}
+	chain = append(chain, 0, 0, 0, 0, 0, 0)
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"short", []byte("II*\x00"), "data too short"},
+		{"byte order", []byte("XX*\x00\x08\x00\x00\x00\x00\x00"), "byte order"},
+		{"magic", []byte("II\x2b\x00\x08\x00\x00\x00\x00\x00"), "magic"},
+		{"ifd offset", []byte("II*\x00\xf0\xff\xff\xff\x00\x00"), "IFD offset out of bounds"},
+		{"entries", []byte("II*\x00\x08\x00\x00\x00\xff\x00\x12\x01\x03\x00"), "IFD entries exceed the data"},
+		{"next cycle", littleTIFF([]tiffEntry{orientation}, 8), "IFD cycle"},
+		{"sub-IFD cycle", littleTIFF([]tiffEntry{orientation, {tagExifIFDPointer, typeUnsignedLong, 1, 8}}, 0), "IFD cycle"},
+		{"sub-IFD offset", littleTIFF([]tiffEntry{orientation, {tagGPSIFDPointer, typeUnsignedLong, 1, 1 << 31}}, 0), "IFD offset out of bounds"},
+		{"chain", chain, "too many IFDs"},
+	} {
+		exif := &Exif{Orientation: 1}
+		err := parseExifData(tc.data, exif)
+
+		var e *ExifError
+		if !errors.As(err, &e) || !errors.Is(err, ErrInvalidExif) {
+			t.Errorf("%s: got %v, want an ExifError", tc.name, err)
+			continue
+		}
+		if !strings.Contains(e.Reason, tc.reason) {
+			t.Errorf("%s: got %q, want %q", tc.name, e.Reason, tc.reason)
+		}
+		if strings.Contains(tc.name, "cycle") && exif.Orientation != 6 {
+			t.Errorf("%s: IFD0 orientation lost", tc.name)
+		}
+	}
+}
+
+func TestParseExifValues(t *testing.T) {
+	// Values outside the data, huge counts and unknown types are skipped, not fatal.
+	data := littleTIFF([]tiffEntry{
+		{tagOrientation, typeUnsignedShort, 1, 3},
+		{tagMake, typeASCIIString, 0xffffffff, 8},
+		{tagModel, typeASCIIString, 3, 'X' | 'Y'<<8},
+		{tagSoftware, 99, 1, 0},
+		{tagGPSIFDPointer, typeUnsignedLong, 1, 0},
+	}, 0)
+
+	// An altitude of 1200/10 meters below sea level, in a GPS IFD after it.
+	altitude := len(data)
+	data = binary.LittleEndian.AppendUint32(data, 1200)
+	data = binary.LittleEndian.AppendUint32(data, 10)
+
+	binary.LittleEndian.PutUint32(data[8+2+4*12+8:], uint32(len(data)))
+	data = littleIFD(data, []tiffEntry{
+		{tagGPSAltitudeRef, typeUnsignedByte, 1, 1},
+		{tagGPSAltitude, typeUnsignedRational, 1, uint32(altitude)},
+		{tagGPSLatitude, typeUnsignedRational, 0xffffffff, 8},
+	}, 0)
+
+	exif := &Exif{Orientation: 1}
+	if err := parseExifData(data, exif); err != nil {
+		t.Fatal(err)
+	}
+
+	if exif.Orientation != 3 || exif.Make != "" || exif.Model != "XY" || exif.Software != "" {
+		t.Errorf("got %+v", exif)
+	}
+	if exif.GPSAltitude != -120 || exif.GPSLatitude != 0 {
+		t.Errorf("got altitude %v latitude %v, want -120 and 0", exif.GPSAltitude, exif.GPSLatitude)
+	}
+
+	if size, ok := getDataSize(typeDoubleFloat, 0xffffffff); !ok || size != 8*0xffffffff {
+		t.Errorf("getDataSize = %d, %v", size, ok)
+	}
+}
+
+// exifWebp wraps TIFF data in a minimal extended WEBP, enough for DecodeExif.
+func exifWebp(tiff []byte) []byte {
+	var b []byte
+	b = append(b, "WEBPEXIF"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(tiff)))
+	b = append(b, tiff...)
+	if len(tiff)%2 == 1 {
+		b = append(b, 0)
+	}
+
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(b))), b...)
+}
+
+func FuzzDecodeExif(f *testing.F) {
+	if data, err := os.ReadFile("testdata/exif.webp"); err == nil {
+		f.Add(data)
+	}
+	f.Add(exifWebp(littleTIFF([]tiffEntry{{tagOrientation, typeUnsignedShort, 1, 6}}, 8)))
+	f.Add(exifWebp(littleTIFF([]tiffEntry{{tagExifIFDPointer, typeUnsignedLong, 1, 26}}, 0, 1, 0, 0x9a, 0x82, 5, 0, 1, 0, 0, 0, 8, 0, 0, 0)))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exif, err := DecodeExif(bytes.NewReader(data))
+		if err != nil {
+			if exif != nil || (err != ErrNoExif && !errors.Is(err, ErrInvalidExif)) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+	})
+}
+
+func FuzzSetExifOrientation(f *testing.F) {
+	f.Add(littleTIFF([]tiffEntry{{tagOrientation, typeUnsignedShort, 1, 6}}, 0), 1)
+	f.Add(littleTIFF([]tiffEntry{{tagMake, typeASCIIString, 4, 'C' | 'a'<<8 | 'm'<<16}}, 0), 8)
+	f.Add([]byte{}, 3)
+
+	f.Fuzz(func(t *testing.T, data []byte, orientation int) {
+		out, err := SetExifOrientation(data, orientation)
+		if err != nil {
+			return
+		}
+
+		// IFD0 was valid, so the new orientation reads back even if sub-IFDs are malformed.
+		exif := &Exif{}
+		parseExifData(bytes.TrimPrefix(out, []byte("Exif\x00\x00")), exif)
+		if exif.Orientation != orientation {
+			t.Fatalf("orientation %d, want %d", exif.Orientation, orientation)
+		}
+	})
+}
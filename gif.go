@@ -0,0 +1,468 @@
+package webp
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"slices"
+)
+
+// FromGIF composites the frames of g the way a browser displays them and returns the animation
+// for EncodeAll, as libwebp's gif2webp does: disposal methods and the transparent index are
+// applied on a transparent canvas, delays are converted from 10ms units and LoopCount is mapped
+// to the WEBP meaning. EncodeAll then stores only the changed sub-rectangles of each frame.
+func FromGIF(g *gif.GIF) *WEBP {
+	width, height := g.Config.Width, g.Config.Height
+	if width == 0 || height == 0 {
+		for _, p := range g.Image {
+			width = max(width, p.Rect.Max.X)
+			height = max(height, p.Rect.Max.Y)
+		}
+	}
+
+	ret := &WEBP{
+		Image:      make([]image.Image, 0, len(g.Image)),
+		Delay:      make([]int, 0, len(g.Image)),
+		LoopCount:  gifLoopCount(g.LoopCount),
+		Background: gifBackground(g),
+		Config:     image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height},
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var previous *image.NRGBA
+
+	for i, p := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas.Rect)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, p.Rect, p, p.Rect.Min, draw.Over)
+
+		frame := image.NewNRGBA(canvas.Rect)
+		copy(frame.Pix, canvas.Pix)
+		ret.Image = append(ret.Image, frame)
+
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i] * 10
+		}
+
+		// Like gif2webp: browsers show frames with a delay of 10ms or less for 100ms.
+		if delay <= 10 {
+			delay = 100
+		}
+
+		ret.Delay = append(ret.Delay, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, p.Rect, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return ret
+}
+
+// EncodeGIF reads a GIF from r and writes it to w as an animated WEBP, see FromGIF.
+// Without options the frames are encoded lossless, which suits palette images best.
+func EncodeGIF(w io.Writer, r io.Reader, o ...Options) error {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(o) == 0 {
+		o = []Options{{Lossless: true, Method: DefaultMethod}}
+	}
+
+	return EncodeAll(w, FromGIF(g), o...)
+}
+
+// gifLoopCount maps the image/gif loop count (0 forever, -1 once, n for n+1 plays)
+// to the number of plays of WEBP (0 forever), capped to the 16 bits of the ANIM chunk.
+func gifLoopCount(n int) int {
+	switch {
+	case n == 0:
+		return 0
+	case n < 0:
+		return 1
+	}
+
+	return min(n+1, 0xffff)
+}
+
+// gifBackground returns the background color of the global palette, or transparent white when
+// it is missing or the transparent color of the first frame, matching gif2webp.
+func gifBackground(g *gif.GIF) color.Color {
+	transparent := color.NRGBA{0xff, 0xff, 0xff, 0}
+	i := int(g.BackgroundIndex)
+
+	p, ok := g.Config.ColorModel.(color.Palette)
+	if !ok || i >= len(p) {
+		return transparent
+	}
+
+	if len(g.Image) > 0 && i < len(g.Image[0].Palette) {
+		if _, _, _, a := g.Image[0].Palette[i].RGBA(); a == 0 {
+			return transparent
+		}
+	}
+
+	return color.NRGBAModel.Convert(p[i])
+}
+
+// GIFOptions are the parameters of ToGIF.
+type GIFOptions struct {
+	// NumColors is the maximum palette size in the range [2,256], including the transparent color. Default is 256.
+	NumColors int
+	// GlobalPalette quantizes all frames to one shared palette instead of one palette per frame.
+	GlobalPalette bool
+	// Dither enables Floyd-Steinberg error diffusion.
+	Dither bool
+	// AlphaThreshold is the alpha below which a pixel becomes transparent. Default is 128.
+	AlphaThreshold uint8
+}
+
+// ErrNoFrames is returned by ToGIF for an animation without frames.
+var ErrNoFrames = errors.New("webp: no frames")
+
+// ToGIF converts the decoded animation anim, e.g. as returned by DecodeAll, to a GIF.
+// Frames are quantized with median cut, delays are rounded to 1/100s units (at least 1) and
+// LoopCount is mapped to the image/gif meaning.
+func ToGIF(anim *WEBP, o ...GIFOptions) (*gif.GIF, error) {
+	if anim == nil || len(anim.Image) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	for i, img := range anim.Image {
+		if img == nil {
+			return nil, fmt.Errorf("%w: frame %d is nil", ErrNoFrames, i)
+		}
+	}
+
+	var opt GIFOptions
+	if len(o) > 0 {
+		opt = o[0]
+	}
+
+	if opt.NumColors < 2 || opt.NumColors > 256 {
+		opt.NumColors = 256
+	}
+
+	if opt.AlphaThreshold == 0 {
+		opt.AlphaThreshold = 128
+	}
+
+	frames := make([]*image.NRGBA, len(anim.Image))
+	transparent := false
+
+	for i, img := range anim.Image {
+		frames[i] = gifFrame(img, opt.AlphaThreshold)
+		transparent = transparent || hasTransparent(frames[i])
+	}
+
+	// One palette entry is kept for the transparent color.
+	colors := opt.NumColors
+	if transparent {
+		colors--
+	}
+
+	var global color.Palette
+	if opt.GlobalPalette {
+		global = medianCut(frames, colors)
+	}
+
+	width, height := anim.Config.Width, anim.Config.Height
+	if width == 0 || height == 0 {
+		width, height = frames[0].Rect.Dx(), frames[0].Rect.Dy()
+	}
+
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(frames)),
+		Delay:     make([]int, len(frames)),
+		Disposal:  make([]byte, len(frames)),
+		LoopCount: webpLoopCount(anim.LoopCount),
+		Config:    image.Config{Width: width, Height: height},
+	}
+
+	for i, frame := range frames {
+		palette := global
+		if palette == nil {
+			palette = medianCut(frames[i:i+1], colors)
+		}
+
+		p := image.NewPaletted(frame.Rect, palette)
+		remap(p, frame, opt.Dither)
+
+		// The transparent color is appended after drawing so opaque pixels never map to it.
+		if transparent {
+			p.Palette = append(palette[:len(palette):len(palette)], color.NRGBA{})
+			for y := 0; y < frame.Rect.Dy(); y++ {
+				for x := 0; x < frame.Rect.Dx(); x++ {
+					if frame.Pix[y*frame.Stride+x*4+3] == 0 {
+						p.Pix[y*p.Stride+x] = uint8(len(palette))
+					}
+				}
+			}
+		}
+
+		g.Image[i] = p
+
+		// A delay of 0 makes browsers use their own default, so short delays keep 1/100s.
+		if i < len(anim.Delay) {
+			g.Delay[i] = max((anim.Delay[i]+5)/10, 1)
+		}
+
+		// Frames are whole canvases, so transparent pixels must not show the previous frame.
+		g.Disposal[i] = gif.DisposalNone
+		if transparent {
+			g.Disposal[i] = gif.DisposalBackground
+		}
+	}
+
+	if global != nil {
+		g.Config.ColorModel = g.Image[0].Palette
+	}
+
+	return g, nil
+}
+
+// webpLoopCount is the inverse of gifLoopCount.
+func webpLoopCount(n int) int {
+	switch n {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	}
+
+	return n - 1
+}
+
+// gifFrame converts img to NRGBA with the pixels below threshold fully transparent and all others opaque.
+func gifFrame(img image.Image, threshold uint8) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	for i := 3; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i] < threshold {
+			clear(dst.Pix[i-3 : i+1])
+		} else {
+			dst.Pix[i] = 0xff
+		}
+	}
+
+	return dst
+}
+
+func hasTransparent(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remap sets the pixels of p to the nearest palette colors of frame, optionally with
+// Floyd-Steinberg error diffusion. Lookups are cached per color, unlike draw.Draw.
+func remap(p *image.Paletted, frame *image.NRGBA, dither bool) {
+	w, h := frame.Rect.Dx(), frame.Rect.Dy()
+	cache := make(map[[3]uint8]uint8)
+
+	nearest := func(rgb [3]uint8) uint8 {
+		if i, ok := cache[rgb]; ok {
+			return i
+		}
+
+		best, bestDist := 0, math.MaxInt
+		for i, c := range p.Palette {
+			n := c.(color.NRGBA)
+			dr, dg, db := int(rgb[0])-int(n.R), int(rgb[1])-int(n.G), int(rgb[2])-int(n.B)
+			if d := dr*dr + dg*dg + db*db; d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+
+		cache[rgb] = uint8(best)
+
+		return uint8(best)
+	}
+
+	// Error rows in 1/16 units, with one pixel of padding on both sides.
+	cur := make([][3]int, w+2)
+	next := make([][3]int, w+2)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			px := frame.Pix[y*frame.Stride+x*4:]
+
+			var rgb [3]uint8
+			for c := range rgb {
+				v := int(px[c])
+				if dither {
+					v += cur[x+1][c] / 16
+				}
+				rgb[c] = uint8(min(max(v, 0), 255))
+			}
+
+			i := nearest(rgb)
+			p.Pix[y*p.Stride+x] = i
+
+			if !dither {
+				continue
+			}
+
+			n := p.Palette[i].(color.NRGBA)
+			for c, v := range [3]uint8{n.R, n.G, n.B} {
+				e := int(rgb[c]) - int(v)
+				cur[x+2][c] += e * 7
+				next[x][c] += e * 3
+				next[x+1][c] += e * 5
+				next[x+2][c] += e * 1
+			}
+		}
+
+		cur, next = next, cur
+		clear(next)
+	}
+}
+
+// colorBox is a box of the RGB color space holding histogram entries, for median cut.
+type colorBox struct {
+	colors []colorCount
+}
+
+type colorCount struct {
+	rgb   [3]uint8
+	count int
+}
+
+// medianCut returns a palette of at most n colors for the opaque pixels of frames. Boxes of the
+// color histogram are split at the weighted median of their widest channel until there are n.
+func medianCut(frames []*image.NRGBA, n int) color.Palette {
+	hist := make(map[[3]uint8]int)
+	for _, f := range frames {
+		for i := 0; i < len(f.Pix); i += 4 {
+			if f.Pix[i+3] != 0 {
+				hist[[3]uint8{f.Pix[i], f.Pix[i+1], f.Pix[i+2]}]++
+			}
+		}
+	}
+
+	all := make([]colorCount, 0, len(hist))
+	for rgb, count := range hist {
+		all = append(all, colorCount{rgb, count})
+	}
+
+	// Sort for a deterministic palette regardless of map order.
+	slices.SortFunc(all, func(a, b colorCount) int {
+		return cmp.Compare(uint32(a.rgb[0])<<16|uint32(a.rgb[1])<<8|uint32(a.rgb[2]),
+			uint32(b.rgb[0])<<16|uint32(b.rgb[1])<<8|uint32(b.rgb[2]))
+	})
+
+	boxes := []colorBox{{all}}
+
+	for len(boxes) < n {
+		// Split the box with the widest channel range.
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.colors) < 2 {
+				continue
+			}
+
+			ch, r := box.widest()
+			if r > bestRange {
+				best, bestChannel, bestRange = i, ch, r
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		colors := boxes[best].colors
+		slices.SortStableFunc(colors, func(a, b colorCount) int {
+			return cmp.Compare(a.rgb[bestChannel], b.rgb[bestChannel])
+		})
+
+		total := 0
+		for _, c := range colors {
+			total += c.count
+		}
+
+		split, sum := 1, 0
+		for i, c := range colors[:len(colors)-1] {
+			sum += c.count
+			if sum*2 >= total {
+				split = i + 1
+				break
+			}
+		}
+
+		boxes[best] = colorBox{colors[:split]}
+		boxes = append(boxes, colorBox{colors[split:]})
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box.colors) > 0 {
+			palette = append(palette, box.average())
+		}
+	}
+
+	if len(palette) == 0 {
+		palette = append(palette, color.NRGBA{A: 0xff})
+	}
+
+	return palette
+}
+
+// widest returns the channel with the largest value range in the box, and that range.
+func (b colorBox) widest() (channel, width int) {
+	lo, hi := [3]uint8{255, 255, 255}, [3]uint8{}
+	for _, c := range b.colors {
+		for i, v := range c.rgb {
+			lo[i] = min(lo[i], v)
+			hi[i] = max(hi[i], v)
+		}
+	}
+
+	for i := range lo {
+		if r := int(hi[i]) - int(lo[i]); r > width {
+			channel, width = i, r
+		}
+	}
+
+	return channel, width
+}
+
+// average is the count-weighted mean color of the box.
+func (b colorBox) average() color.NRGBA {
+	var sum [3]int
+	total := 0
+	for _, c := range b.colors {
+		for i, v := range c.rgb {
+			sum[i] += int(v) * c.count
+		}
+		total += c.count
+	}
+
+	return color.NRGBA{uint8((sum[0] + total/2) / total), uint8((sum[1] + total/2) / total), uint8((sum[2] + total/2) / total), 0xff}
+}
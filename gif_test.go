@@ -0,0 +1,216 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestEncodeGIF(t *testing.T) {
+	palette := color.Palette{
+		color.NRGBA{0, 0, 0, 0},
+		color.NRGBA{255, 0, 0, 255},
+		color.NRGBA{0, 255, 0, 255},
+		color.NRGBA{0, 0, 255, 255},
+	}
+
+	frame := func(r image.Rectangle, index uint8) *image.Paletted {
+		p := image.NewPaletted(r, palette)
+		for i := range p.Pix {
+			p.Pix[i] = index
+		}
+		return p
+	}
+
+	green := frame(image.Rect(4, 4, 12, 12), 2)
+	green.SetColorIndex(4, 4, 0)
+
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 16, 16), 1),
+			green,
+			frame(image.Rect(0, 0, 4, 4), 3),
+			frame(image.Rect(12, 12, 14, 14), 3),
+		},
+		Delay:     []int{0, 5, 10, 20},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 2,
+		Config:    image.Config{ColorModel: palette, Width: 16, Height: 16},
+	}
+
+	var src bytes.Buffer
+	if err := gif.EncodeAll(&src, g); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGIF(&buf, &src, Options{Lossless: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.LoopCount != 3 {
+		t.Errorf("got loop count %d, want 3", got.LoopCount)
+	}
+
+	wantDelay := []int{100, 50, 100, 200}
+	if len(got.Image) != len(wantDelay) {
+		t.Fatalf("got %d frames, want %d", len(got.Image), len(wantDelay))
+	}
+
+	red, blue := palette[1].(color.NRGBA), palette[3].(color.NRGBA)
+	hole := image.Rect(4, 4, 12, 12)
+
+	// want returns the expected color of pixel x,y in frame i.
+	want := func(i, x, y int) color.NRGBA {
+		p := image.Pt(x, y)
+		switch i {
+		case 1:
+			if p.In(hole) && p != image.Pt(4, 4) {
+				return palette[2].(color.NRGBA)
+			}
+		case 2:
+			if p.In(image.Rect(0, 0, 4, 4)) {
+				return blue
+			}
+			if p.In(hole) {
+				return color.NRGBA{}
+			}
+		case 3:
+			if p.In(image.Rect(12, 12, 14, 14)) {
+				return blue
+			}
+			if p.In(hole) {
+				return color.NRGBA{}
+			}
+		}
+		return red
+	}
+
+	for i, img := range got.Image {
+		if got.Delay[i] != wantDelay[i] {
+			t.Errorf("frame %d: delay %d, want %d", i, got.Delay[i], wantDelay[i])
+		}
+
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				c, w := img.(*image.NRGBA).NRGBAAt(x, y), want(i, x, y)
+				if c != w && (c.A != 0 || w.A != 0) {
+					t.Fatalf("frame %d: pixel %d,%d is %v, want %v", i, x, y, c, w)
+				}
+			}
+		}
+	}
+}
+
+func TestGIFLoopCount(t *testing.T) {
+	for _, tt := range []struct{ in, want int }{{0, 0}, {-1, 1}, {1, 2}, {0xffff, 0xffff}} {
+		if got := gifLoopCount(tt.in); got != tt.want {
+			t.Errorf("gifLoopCount(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestToGIF(t *testing.T) {
+	anim, err := DecodeAll(bytes.NewReader(testWebpAnim), Options{ColorMode: ModeNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opt := range []GIFOptions{{}, {GlobalPalette: true}, {Dither: true, NumColors: 64}} {
+		out, err := ToGIF(anim, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, out); err != nil {
+			t.Fatal(err)
+		}
+
+		g, err := gif.DecodeAll(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(g.Image) != len(anim.Image) {
+			t.Fatalf("%+v: got %d frames, want %d", opt, len(g.Image), len(anim.Image))
+		}
+
+		if gifLoopCount(g.LoopCount) != anim.LoopCount {
+			t.Errorf("%+v: loop count %d does not map back to %d", opt, g.LoopCount, anim.LoopCount)
+		}
+
+		for i, p := range g.Image {
+			if want := max((anim.Delay[i]+5)/10, 1); g.Delay[i] != want {
+				t.Errorf("%+v: frame %d delay %d, want %d", opt, i, g.Delay[i], want)
+			}
+
+			if len(p.Palette) > max(opt.NumColors, 256) || (opt.NumColors > 0 && len(p.Palette) > opt.NumColors) {
+				t.Errorf("%+v: frame %d has %d colors", opt, i, len(p.Palette))
+			}
+
+			if d := meanDiff(imageToNRGBA(p), anim.Image[i].(*image.NRGBA)); d > 8 {
+				t.Errorf("%+v: frame %d differs by %.2f on average", opt, i, d)
+			}
+		}
+	}
+}
+
+func TestToGIFTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 32), 0, 0, uint8(y * 32)})
+		}
+	}
+
+	g, err := ToGIF(&WEBP{Image: []image.Image{img}, Delay: []int{40}, LoopCount: 1}, GIFOptions{AlphaThreshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.LoopCount != -1 {
+		t.Errorf("got loop count %d, want -1", g.LoopCount)
+	}
+	if g.Disposal[0] != gif.DisposalBackground {
+		t.Errorf("got disposal %d, want background", g.Disposal[0])
+	}
+
+	p := g.Image[0]
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			_, _, _, a := p.At(x, y).RGBA()
+			if transparent := y*32 < 100; transparent != (a == 0) {
+				t.Errorf("pixel %d,%d: alpha %d", x, y, a)
+			}
+			if c := p.At(x, y).(color.NRGBA); c.A != 0 && c.R != uint8(x*32) {
+				t.Errorf("pixel %d,%d: got %v", x, y, c)
+			}
+		}
+	}
+}
+
+func TestToGIFInvalid(t *testing.T) {
+	for _, anim := range []*WEBP{nil, {}, {Image: []image.Image{nil}}} {
+		if _, err := ToGIF(anim, GIFOptions{GlobalPalette: true}); !errors.Is(err, ErrNoFrames) {
+			t.Errorf("%+v: got %v, want ErrNoFrames", anim, err)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	g, err := ToGIF(&WEBP{Image: []image.Image{img, img}, Delay: []int{0, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Delay[0] != 1 || g.Delay[1] != 1 {
+		t.Errorf("got delays %v, want [1 1]", g.Delay)
+	}
+}
@@ -0,0 +1,258 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// Chunk is a RIFF chunk of a WEBP file as reported by Inspect.
+type Chunk struct {
+	FourCC string `json:"fourcc"`
+	// Offset of the chunk header from the start of the file.
+	Offset int `json:"offset"`
+	// Size of the chunk payload, without header and padding.
+	Size int `json:"size"`
+	// Chunks are the sub-chunks of an ANMF chunk.
+	Chunks []Chunk `json:"chunks,omitempty"`
+}
+
+// FrameInfo describes an image or animation frame as reported by Inspect.
+type FrameInfo struct {
+	X        int  `json:"x"`
+	Y        int  `json:"y"`
+	Width    int  `json:"width"`
+	Height   int  `json:"height"`
+	Duration int  `json:"duration"`
+	Blend    bool `json:"blend"`
+	Dispose  bool `json:"dispose"`
+	// Lossless is true for a VP8L bitstream, false for VP8.
+	Lossless bool `json:"lossless"`
+	// Alpha is true when the frame carries an ALPH chunk or VP8L alpha.
+	Alpha bool `json:"alpha"`
+}
+
+// Info is the container layout of a WEBP file, reported by Inspect without decoding any pixels.
+type Info struct {
+	// FileSize is the size of the whole input.
+	FileSize int `json:"file_size"`
+	// Chunks is the RIFF chunk tree.
+	Chunks []Chunk `json:"chunks"`
+	// Extended is true for files starting with a VP8X chunk.
+	Extended bool `json:"extended"`
+	// VP8X feature flags.
+	ICC       bool `json:"icc"`
+	Alpha     bool `json:"alpha"`
+	EXIF      bool `json:"exif"`
+	XMP       bool `json:"xmp"`
+	Animation bool `json:"animation"`
+	// Canvas size.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// LoopCount and Background are the ANIM chunk parameters.
+	LoopCount  int         `json:"loop_count"`
+	Background color.NRGBA `json:"background"`
+	// Frames holds the ANMF frames, or the single image of a still file.
+	Frames []FrameInfo `json:"frames"`
+	// Warnings lists the inconsistencies found, such as flags without chunks.
+	Warnings []string `json:"warnings,omitempty"`
+	// Partial is true when the container could not be parsed, e.g. for a truncated file.
+	// Chunks then lists the chunks before the broken one and Warnings starts with the error.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Inspect parses the RIFF structure of a WEBP file and checks it for inconsistencies, which
+// are reported as Info.Warnings. A RIFF WEBP file that fails to parse is reported as a
+// Partial Info; only read errors and input that is not RIFF WEBP at all are returned as errors.
+func Inspect(r io.Reader) (*Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseContainer(data)
+	if err != nil {
+		return inspectPartial(data, err)
+	}
+
+	info := &Info{
+		FileSize:   len(data),
+		Extended:   c.chunks[0].fourcc == "VP8X",
+		ICC:        c.flags&flagICCP != 0,
+		Alpha:      c.flags&flagAlpha != 0,
+		EXIF:       c.flags&flagEXIF != 0,
+		XMP:        c.flags&flagXMP != 0,
+		Animation:  c.flags&flagAnimation != 0,
+		Width:      c.width,
+		Height:     c.height,
+		LoopCount:  c.loopCount,
+		Background: argbToColor(c.bgcolor),
+	}
+
+	warn := func(format string, args ...any) {
+		info.Warnings = append(info.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	if size := 8 + int(binary.LittleEndian.Uint32(data[4:8])); size < len(data) {
+		warn("%d bytes of trailing data after the RIFF chunk", len(data)-size)
+	}
+
+	seen := make(map[string]int)
+	imageSeen := false
+
+	for _, ch := range c.chunks {
+		info.Chunks = append(info.Chunks, chunkInfo(ch))
+		seen[ch.fourcc]++
+
+		switch ch.fourcc {
+		case "VP8X":
+		case "ICCP":
+			if imageSeen {
+				warn("ICCP chunk after the image data")
+			}
+		case "ANIM":
+			if imageSeen {
+				warn("ANIM chunk after the image data")
+			}
+		case "ALPH", "VP8 ", "VP8L", "ANMF":
+			imageSeen = true
+		case "EXIF", "XMP ":
+			if !imageSeen {
+				warn("%q chunk before the image data", ch.fourcc)
+			}
+		default:
+			warn("unknown chunk %q at offset %d", ch.fourcc, ch.offset)
+		}
+	}
+
+	if info.Extended {
+		for _, f := range []struct {
+			flag   bool
+			fourcc string
+		}{{info.ICC, "ICCP"}, {info.EXIF, "EXIF"}, {info.XMP, "XMP "}, {info.Animation, "ANIM"}} {
+			switch {
+			case f.flag && seen[f.fourcc] == 0:
+				warn("VP8X flag set but no %q chunk", f.fourcc)
+			case !f.flag && seen[f.fourcc] > 0:
+				warn("%q chunk present but its VP8X flag is not set", f.fourcc)
+			}
+
+			if seen[f.fourcc] > 1 {
+				warn("%d %q chunks", seen[f.fourcc], f.fourcc)
+			}
+		}
+
+		if !info.Animation && seen["ANMF"] > 0 {
+			warn("ANMF chunks in a still image")
+		}
+	}
+
+	if info.Animation {
+		for _, f := range c.frames {
+			info.Frames = append(info.Frames, frameInfo(f.chunks, f.x, f.y, f.width, f.height, f.duration, f.blend, f.dispose, warn))
+		}
+	} else {
+		info.Frames = append(info.Frames, frameInfo(c.chunks, 0, 0, c.width, c.height, 0, false, false, warn))
+	}
+
+	alpha := false
+	for _, f := range info.Frames {
+		alpha = alpha || f.Alpha
+	}
+
+	if info.Extended && alpha && !info.Alpha {
+		warn("image has alpha but the VP8X alpha flag is not set")
+	}
+
+	if !info.Extended {
+		info.Alpha = alpha
+	}
+
+	return info, nil
+}
+
+// inspectPartial reports the chunks of a file parseContainer rejected with err, up to the
+// first broken one, and the VP8X features when the first chunk is intact.
+func inspectPartial(data []byte, err error) (*Info, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, err
+	}
+
+	info := &Info{FileSize: len(data), Partial: true, Warnings: []string{err.Error()}}
+
+	end := min(8+int(binary.LittleEndian.Uint32(data[4:8])), len(data))
+	chunks, _ := parseChunks(data[:end], 12)
+
+	for _, ch := range chunks {
+		info.Chunks = append(info.Chunks, chunkInfo(ch))
+	}
+
+	if len(chunks) > 0 && chunks[0].fourcc == "VP8X" && len(chunks[0].data) >= 10 {
+		flags := chunks[0].data[0]
+		info.Extended = true
+		info.ICC = flags&flagICCP != 0
+		info.Alpha = flags&flagAlpha != 0
+		info.EXIF = flags&flagEXIF != 0
+		info.XMP = flags&flagXMP != 0
+		info.Animation = flags&flagAnimation != 0
+		info.Width = int(load24(chunks[0].data[4:])) + 1
+		info.Height = int(load24(chunks[0].data[7:])) + 1
+	}
+
+	return info, nil
+}
+
+// chunkInfo returns the Chunk of ch, with the sub-chunks of an ANMF.
+func chunkInfo(ch chunk) Chunk {
+	ci := Chunk{FourCC: ch.fourcc, Offset: ch.offset, Size: len(ch.data)}
+
+	if ch.fourcc == "ANMF" {
+		if f, err := parseFrame(ch); err == nil {
+			for _, sub := range f.chunks {
+				ci.Chunks = append(ci.Chunks, chunkInfo(sub))
+			}
+		}
+	}
+
+	return ci
+}
+
+// frameInfo describes the image chunks of a frame, warning about a missing or mis-sized bitstream.
+func frameInfo(chunks []chunk, x, y, width, height, duration int, blend, dispose bool, warn func(string, ...any)) FrameInfo {
+	f := FrameInfo{X: x, Y: y, Width: width, Height: height, Duration: duration, Blend: blend, Dispose: dispose}
+
+	var bs *chunk
+	for i := range chunks {
+		switch chunks[i].fourcc {
+		case "ALPH":
+			f.Alpha = true
+		case "VP8 ", "VP8L":
+			bs = &chunks[i]
+		}
+	}
+
+	if bs == nil {
+		warn("frame at %d,%d has no bitstream", x, y)
+		return f
+	}
+
+	f.Lossless = bs.fourcc == "VP8L"
+	if f.Lossless {
+		if f.Alpha {
+			warn("ALPH chunk with a lossless bitstream at offset %d", bs.offset)
+		}
+
+		f.Alpha = hasAlpha(*bs)
+	}
+
+	w, h, err := bitstreamSize(*bs)
+	switch {
+	case err != nil:
+		warn("%v at offset %d", err, bs.offset)
+	case w != width || h != height:
+		warn("bitstream at offset %d is %dx%d, frame is %dx%d", bs.offset, w, h, width, height)
+	}
+
+	return f
+}
@@ -0,0 +1,107 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	info, err := Inspect(bytes.NewReader(testWebpAnim))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Extended || !info.Animation || len(info.Frames) != 17 {
+		t.Errorf("got extended %v, animation %v, %d frames", info.Extended, info.Animation, len(info.Frames))
+	}
+	if len(info.Warnings) != 0 {
+		t.Errorf("unexpected warnings %q", info.Warnings)
+	}
+
+	anmf := 0
+	for _, ch := range info.Chunks {
+		if ch.FourCC == "ANMF" {
+			anmf++
+			if len(ch.Chunks) == 0 {
+				t.Errorf("ANMF at %d without sub-chunks", ch.Offset)
+			}
+		}
+	}
+	if anmf != 17 {
+		t.Errorf("got %d ANMF chunks, want 17", anmf)
+	}
+
+	info, err = Inspect(bytes.NewReader(testWebp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Extended || info.Width != 512 || info.Height != 512 || len(info.Frames) != 1 || info.Frames[0].Lossless {
+		t.Errorf("got %+v", info)
+	}
+
+	data, err := os.ReadFile("testdata/exif.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.EXIF || len(info.Warnings) != 0 {
+		t.Errorf("got exif %v, warnings %q", info.EXIF, info.Warnings)
+	}
+}
+
+func TestInspectWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SetMetadata(&buf, bytes.NewReader(testWebp), Metadata{XMP: []byte("<x/>")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claim EXIF and drop the XMP flag, then append trailing garbage.
+	data := buf.Bytes()
+	data[20] = data[20]&^flagXMP | flagEXIF
+	data = append(data, "junk"...)
+
+	info, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"4 bytes of trailing data after the RIFF chunk",
+		`VP8X flag set but no "EXIF" chunk`,
+		`"XMP " chunk present but its VP8X flag is not set`,
+	}
+
+	if len(info.Warnings) != len(want) {
+		t.Fatalf("got warnings %q, want %q", info.Warnings, want)
+	}
+	for i := range want {
+		if info.Warnings[i] != want[i] {
+			t.Errorf("warning %d: got %q, want %q", i, info.Warnings[i], want[i])
+		}
+	}
+}
+
+func TestInspectTruncated(t *testing.T) {
+	info, err := Inspect(bytes.NewReader(testWebpAnim[:len(testWebpAnim)/2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Partial || len(info.Warnings) != 1 || len(info.Chunks) < 3 {
+		t.Fatalf("got partial %v, %d chunks, warnings %q", info.Partial, len(info.Chunks), info.Warnings)
+	}
+
+	if info.Chunks[0].FourCC != "VP8X" || info.Chunks[1].FourCC != "ANIM" || !info.Animation || info.Width == 0 {
+		t.Errorf("got %+v", info)
+	}
+
+	if _, err := Inspect(bytes.NewReader([]byte("junk"))); !errors.Is(err, ErrDecode) {
+		t.Errorf("got %v, want ErrDecode", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Package cli parses the flag values shared by the command-line tools.
+package cli
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/webp"
+)
+
+// ParseBackend parses a -backend value: auto, dynamic or wasm.
+func ParseBackend(s string) (webp.Backend, error) {
+	switch s {
+	case "auto":
+		return webp.BackendAuto, nil
+	case "dynamic":
+		return webp.BackendDynamic, nil
+	case "wasm":
+		return webp.BackendWasm, nil
+	}
+
+	return webp.BackendAuto, fmt.Errorf("unknown backend %q", s)
+}
+
+// Quality checks a -q value and rounds it to the integer quality of webp.Options. Values below 1
+// are rejected, since quality 0 selects the default quality rather than the lowest.
+func Quality(q float64) (int, error) {
+	if q < 1 || q > 100 {
+		return 0, fmt.Errorf("quality %v out of range [1,100]", q)
+	}
+
+	return int(q + 0.5), nil
+}
+
+// CheckMethod checks a -m value.
+func CheckMethod(m int) error {
+	if m < 0 || m > 6 {
+		return fmt.Errorf("method %d out of range [0,6]", m)
+	}
+
+	return nil
+}
+
+// ParseSize parses a WxH size, where one of the sides may be 0 to keep the aspect ratio.
+func ParseSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q, want WxH", s)
+	}
+
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil || w < 0 || h < 0 || (w == 0 && h == 0) {
+		return 0, 0, fmt.Errorf("invalid size %q, want WxH", s)
+	}
+
+	return w, h, nil
+}
+
+// ParseCrop parses an x,y,w,h rectangle.
+func ParseCrop(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid crop %q, want x,y,w,h", s)
+	}
+
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return image.Rectangle{}, fmt.Errorf("invalid crop %q, want x,y,w,h", s)
+		}
+		v[i] = n
+	}
+
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// Crop returns the part r of img, where r is relative to the image origin.
+func Crop(img image.Image, r image.Rectangle) (image.Image, error) {
+	b := img.Bounds()
+	r = r.Add(b.Min)
+	if r.Empty() || !r.In(b) {
+		return nil, fmt.Errorf("crop %v outside the %dx%d image", r.Sub(b.Min), b.Dx(), b.Dy())
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Rect, img, r.Min, draw.Src)
+
+	return dst, nil
+}
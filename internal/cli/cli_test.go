@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"image"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+func TestParseBackend(t *testing.T) {
+	for s, want := range map[string]webp.Backend{"auto": webp.BackendAuto, "dynamic": webp.BackendDynamic, "wasm": webp.BackendWasm} {
+		if got, err := ParseBackend(s); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", s, got, err)
+		}
+	}
+
+	if _, err := ParseBackend("gpu"); err == nil {
+		t.Error("parsed gpu")
+	}
+}
+
+func TestQuality(t *testing.T) {
+	for q, want := range map[float64]int{1: 1, 74.5: 75, 100: 100} {
+		if got, err := Quality(q); err != nil || got != want {
+			t.Errorf("%v: got %d, %v", q, got, err)
+		}
+	}
+
+	for _, q := range []float64{0, 0.9, -1, 100.5} {
+		if _, err := Quality(q); err == nil {
+			t.Errorf("accepted quality %v", q)
+		}
+	}
+}
+
+func TestCheckMethod(t *testing.T) {
+	for m := -1; m <= 7; m++ {
+		if err := CheckMethod(m); (err == nil) != (m >= 0 && m <= 6) {
+			t.Errorf("method %d: got %v", m, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	if w, h, err := ParseSize("20x0"); err != nil || w != 20 || h != 0 {
+		t.Errorf("got %dx%d, %v", w, h, err)
+	}
+
+	for _, s := range []string{"", "20", "0x0", "-1x5", "ax5"} {
+		if _, _, err := ParseSize(s); err == nil {
+			t.Errorf("parsed %q", s)
+		}
+	}
+}
+
+func TestParseCrop(t *testing.T) {
+	if r, err := ParseCrop("2, 4,20,10"); err != nil || r != image.Rect(2, 4, 22, 14) {
+		t.Errorf("got %v, %v", r, err)
+	}
+
+	for _, s := range []string{"", "1,2,3", "1,2,3,-4", "a,b,c,d"} {
+		if _, err := ParseCrop(s); err == nil {
+			t.Errorf("parsed %q", s)
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	img := image.NewGray(image.Rect(10, 10, 30, 20))
+	img.Pix[img.PixOffset(12, 13)] = 200
+
+	dst, err := Crop(img, image.Rect(2, 3, 6, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.Bounds() != image.Rect(0, 0, 4, 5) {
+		t.Errorf("got bounds %v", dst.Bounds())
+	}
+	if r, _, _, _ := dst.At(0, 0).RGBA(); r>>8 != 200 {
+		t.Errorf("got %d at the origin, want 200", r>>8)
+	}
+
+	if _, err := Crop(img, image.Rect(15, 0, 25, 5)); err == nil {
+		t.Error("cropped outside the image")
+	}
+}
@@ -0,0 +1,131 @@
+// Package resample scales images for the command-line tools.
+package resample
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Resize scales src to width x height with a separable triangle filter, widened when
+// downscaling so every source pixel contributes. Color is weighted by alpha so transparent
+// pixels do not bleed into their neighbours. A zero width or height keeps the aspect ratio.
+func Resize(src image.Image, width, height int) *image.NRGBA {
+	b := src.Bounds()
+	if width <= 0 && height <= 0 {
+		width, height = b.Dx(), b.Dy()
+	} else if width <= 0 {
+		width = max(1, int(math.Round(float64(b.Dx())*float64(height)/float64(b.Dy()))))
+	} else if height <= 0 {
+		height = max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+	}
+
+	in := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(in, in.Rect, src, b.Min, draw.Src)
+
+	if width == b.Dx() && height == b.Dy() {
+		return in
+	}
+
+	// Premultiplied float pixels, scaled horizontally then vertically.
+	pix := make([]float64, len(in.Pix))
+	for i := 0; i < len(in.Pix); i += 4 {
+		a := float64(in.Pix[i+3])
+		pix[i] = float64(in.Pix[i]) * a
+		pix[i+1] = float64(in.Pix[i+1]) * a
+		pix[i+2] = float64(in.Pix[i+2]) * a
+		pix[i+3] = a
+	}
+
+	pix = scale(pix, b.Dx(), b.Dy(), width, true)
+	pix = scale(pix, width, b.Dy(), height, false)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := pix[i+3]
+		if a <= 0 {
+			continue
+		}
+
+		dst.Pix[i] = clamp(pix[i] / a)
+		dst.Pix[i+1] = clamp(pix[i+1] / a)
+		dst.Pix[i+2] = clamp(pix[i+2] / a)
+		dst.Pix[i+3] = clamp(a)
+	}
+
+	return dst
+}
+
+// scale resamples pix (w x h, 4 channels) to n columns (horizontal) or n rows.
+func scale(pix []float64, w, h, n int, horizontal bool) []float64 {
+	size := h
+	if horizontal {
+		size = w
+	}
+
+	ratio := float64(size) / float64(n)
+	support := max(ratio, 1)
+
+	var out []float64
+	if horizontal {
+		out = make([]float64, n*h*4)
+	} else {
+		out = make([]float64, w*n*4)
+	}
+
+	for i := 0; i < n; i++ {
+		center := (float64(i)+0.5)*ratio - 0.5
+		lo := max(int(math.Floor(center-support)), 0)
+		hi := min(int(math.Ceil(center+support)), size-1)
+
+		weights := make([]float64, 0, hi-lo+1)
+		sum := 0.0
+		for j := lo; j <= hi; j++ {
+			wt := max(1-math.Abs(float64(j)-center)/support, 0)
+			weights = append(weights, wt)
+			sum += wt
+		}
+
+		for k := range weights {
+			weights[k] /= sum
+		}
+
+		lines := h
+		if !horizontal {
+			lines = w
+		}
+
+		for l := 0; l < lines; l++ {
+			var acc [4]float64
+			for k, wt := range weights {
+				j := lo + k
+
+				var off int
+				if horizontal {
+					off = (l*w + j) * 4
+				} else {
+					off = (j*w + l) * 4
+				}
+
+				for c := range acc {
+					acc[c] += pix[off+c] * wt
+				}
+			}
+
+			var off int
+			if horizontal {
+				off = (l*n + i) * 4
+			} else {
+				off = (i*w + l) * 4
+			}
+
+			copy(out[off:off+4], acc[:])
+		}
+	}
+
+	return out
+}
+
+func clamp(v float64) uint8 {
+	return uint8(min(max(math.Round(v), 0), 255))
+}
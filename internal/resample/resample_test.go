@@ -0,0 +1,41 @@
+package resample
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.NRGBA{255, 0, 0, 255}
+			if x >= 20 {
+				c = color.NRGBA{0, 0, 255, 0}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	dst := Resize(src, 10, 0)
+	if dst.Rect.Dx() != 10 || dst.Rect.Dy() != 5 {
+		t.Fatalf("got %v, want 10x5", dst.Rect)
+	}
+
+	// Transparent blue must not tint the red half.
+	if c := dst.NRGBAAt(4, 2); c.B != 0 || c.R != 255 {
+		t.Errorf("got %v at the edge", c)
+	}
+	if c := dst.NRGBAAt(0, 0); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("got %v, want opaque red", c)
+	}
+	if c := dst.NRGBAAt(9, 4); c.A != 0 {
+		t.Errorf("got %v, want transparent", c)
+	}
+
+	up := Resize(src, 80, 40)
+	if c := up.NRGBAAt(10, 10); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("got %v, want opaque red", c)
+	}
+}
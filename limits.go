@@ -0,0 +1,179 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Limits bound the resources a Decode/DecodeAll call may use. They are checked against the
+// container headers before any pixel memory is allocated. Zero fields are unlimited.
+type Limits struct {
+	// MaxWidth is the maximum canvas width in pixels.
+	MaxWidth int
+	// MaxHeight is the maximum canvas height in pixels.
+	MaxHeight int
+	// MaxPixels is the maximum canvas area (width*height) in pixels.
+	MaxPixels int
+	// MaxFrames is the maximum number of animation frames.
+	MaxFrames int
+	// MaxBytes is the maximum size in bytes of all decoded frames together.
+	MaxBytes int
+}
+
+// check validates data against l. The dimensions, and the decoded size of a still image, only
+// need the header; the frame count and decoded size of an animation are checked once the whole
+// file is in data (full).
+func (l Limits) check(data []byte, full, decodeAll bool, mode ColorMode) error {
+	width, height, animation, err := headerSize(data)
+	if err != nil {
+		return err
+	}
+
+	if err := l.checkSize(width, height); err != nil {
+		return err
+	}
+
+	if l.MaxFrames <= 0 && l.MaxBytes <= 0 {
+		return nil
+	}
+
+	frames := 1
+	if animation {
+		if !full {
+			return nil
+		}
+
+		c, err := parseContainer(data)
+		if err != nil {
+			return err
+		}
+
+		frames = len(c.frames)
+	}
+
+	if l.MaxFrames > 0 && frames > l.MaxFrames {
+		return fmt.Errorf("%w: %d frames > %d", ErrLimit, frames, l.MaxFrames)
+	}
+
+	if !decodeAll {
+		frames = 1
+	}
+
+	return l.checkBytes(frames * frameBytes(width, height, mode, animation))
+}
+
+// checkSize validates the canvas dimensions against l.
+func (l Limits) checkSize(width, height int) error {
+	if l.MaxWidth > 0 && width > l.MaxWidth {
+		return fmt.Errorf("%w: width %d > %d", ErrLimit, width, l.MaxWidth)
+	}
+
+	if l.MaxHeight > 0 && height > l.MaxHeight {
+		return fmt.Errorf("%w: height %d > %d", ErrLimit, height, l.MaxHeight)
+	}
+
+	pixels := width * height
+	if l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return fmt.Errorf("%w: %d pixels > %d", ErrLimit, pixels, l.MaxPixels)
+	}
+
+	return nil
+}
+
+// checkBytes validates the size of all decoded frames together against l.
+func (l Limits) checkBytes(size int) error {
+	if l.MaxBytes > 0 && size > l.MaxBytes {
+		return fmt.Errorf("%w: %d decoded bytes > %d", ErrLimit, size, l.MaxBytes)
+	}
+
+	return nil
+}
+
+// headerSize reads the canvas size and animation flag from the first chunk, which fits in webpMaxHeaderSize bytes.
+func headerSize(data []byte) (width, height int, animation bool, err error) {
+	if len(data) < 20 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false, fmt.Errorf("%w: not a RIFF WEBP file", ErrDecode)
+	}
+
+	fourcc := string(data[12:16])
+	size := int(binary.LittleEndian.Uint32(data[16:20]))
+	payload := data[20:]
+	if size < len(payload) {
+		payload = payload[:size]
+	}
+
+	if fourcc == "VP8X" {
+		if len(payload) < 10 {
+			return 0, 0, false, fmt.Errorf("%w: VP8X chunk too short", ErrDecode)
+		}
+
+		width = int(load24(payload[4:])) + 1
+		height = int(load24(payload[7:])) + 1
+
+		return width, height, payload[0]&flagAnimation != 0, nil
+	}
+
+	width, height, err = bitstreamSize(chunk{fourcc: fourcc, data: payload})
+
+	return width, height, false, err
+}
+
+// read reads a WEBP file from r for a decode under l. The header is checked before the rest
+// of the file is read, and that is capped at the RIFF size, so an oversized input is rejected
+// without buffering it. With configOnly only the header is read.
+func (l Limits) read(r io.Reader, configOnly, decodeAll bool, mode ColorMode) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, webpMaxHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.check(data, false, decodeAll, mode); err != nil {
+		return nil, err
+	}
+
+	if configOnly {
+		return data, nil
+	}
+
+	rest, err := io.ReadAll(io.LimitReader(r, max(riffLength(data)-int64(len(data)), 0)))
+	if err != nil {
+		return nil, err
+	}
+
+	data = append(data, rest...)
+	if err := l.check(data, true, decodeAll, mode); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readAll reads the whole WEBP file from r, for the decodes that need it before decodeWEBP,
+// through Limits.read when o sets limits.
+func (o Options) readAll(r io.Reader, decodeAll bool) ([]byte, error) {
+	if o.Limits == (Limits{}) {
+		return io.ReadAll(r)
+	}
+
+	return o.Limits.read(r, false, decodeAll, o.ColorMode)
+}
+
+// riffLength returns the length of the file declared by the RIFF header in data, which
+// headerSize has validated.
+func riffLength(data []byte) int64 {
+	return int64(binary.LittleEndian.Uint32(data[4:8])) + 8
+}
+
+// frameBytes is the size of one decoded frame in the Go image returned for mode.
+func frameBytes(width, height int, mode ColorMode, animation bool) int {
+	switch {
+	case mode == ModeGray:
+		return width * height
+	case mode == ModeYCbCrA || (mode == ModeDefault && !animation):
+		cw, ch := (width+1)/2, (height+1)/2
+		return 2*width*height + 2*cw*ch
+	}
+
+	return width * height * 4
+}
@@ -0,0 +1,80 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		all    bool
+		limits Limits
+		err    error
+	}{
+		{"width", testWebp, false, Limits{MaxWidth: 511}, ErrLimit},
+		{"height", testWebp, false, Limits{MaxHeight: 511}, ErrLimit},
+		{"pixels", testWebp, false, Limits{MaxPixels: 512*512 - 1}, ErrLimit},
+		{"fits", testWebp, false, Limits{MaxWidth: 512, MaxHeight: 512, MaxPixels: 512 * 512, MaxFrames: 1}, nil},
+		{"frames", testWebpAnim, true, Limits{MaxFrames: 16}, ErrLimit},
+		{"frames first only", testWebpAnim, false, Limits{MaxFrames: 16}, ErrLimit},
+		{"bytes", testWebpAnim, true, Limits{MaxBytes: 1 << 20}, ErrLimit},
+		{"bytes first only", testWebpAnim, false, Limits{MaxBytes: 1 << 20}, nil},
+	}
+
+	for _, tt := range tests {
+		var err error
+		if tt.all {
+			_, err = DecodeAll(bytes.NewReader(tt.data), Options{Limits: tt.limits})
+		} else {
+			_, err = Decode(bytes.NewReader(tt.data), Options{Limits: tt.limits})
+		}
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDecodeLimitsBomb(t *testing.T) {
+	// A VP8X header declaring a 16384x16384 animated canvas, with nothing behind it.
+	vp8x := make([]byte, 10)
+	vp8x[0] = flagAnimation
+	store24(vp8x[4:], 16383)
+	store24(vp8x[7:], 16383)
+
+	anim := make([]byte, 6)
+	data := riffWrap(appendChunk(appendChunk(nil, "VP8X", vp8x), "ANIM", anim))
+
+	_, err := DecodeAll(bytes.NewReader(data), Options{Limits: Limits{MaxPixels: 4096 * 4096}})
+	if !errors.Is(err, ErrLimit) {
+		t.Errorf("got %v, want ErrLimit", err)
+	}
+}
+
+func TestDecodeLimitsRead(t *testing.T) {
+	data := append(bytes.Clone(testWebp), make([]byte, 1<<20)...)
+
+	// AutoRotate needs the whole file for the EXIF, but reads it the same bounded way.
+	for _, rotate := range []bool{false, true} {
+		// A rejected header stops the read before the image data.
+		r := &countingReader{r: bytes.NewReader(testWebp)}
+		if _, err := Decode(r, Options{AutoRotate: rotate, Limits: Limits{MaxWidth: 16}}); !errors.Is(err, ErrLimit) {
+			t.Fatalf("rotate %v: got %v, want ErrLimit", rotate, err)
+		}
+		if r.n > webpMaxHeaderSize {
+			t.Errorf("rotate %v: read %d bytes before rejecting the header", rotate, r.n)
+		}
+
+		// Data past the RIFF size is not read.
+		r = &countingReader{r: bytes.NewReader(data)}
+		if _, err := DecodeAll(r, Options{AutoRotate: rotate, Limits: Limits{MaxWidth: 4096}}); err != nil {
+			t.Fatal(err)
+		}
+		if r.n != int64(len(testWebp)) {
+			t.Errorf("rotate %v: read %d bytes, want %d", rotate, r.n, len(testWebp))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package webp
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Metadata holds the raw metadata chunks of a WEBP file.
+type Metadata struct {
+	// EXIF is the TIFF/EXIF payload of the EXIF chunk.
+	EXIF []byte
+	// ICCP is the ICC color profile of the ICCP chunk.
+	ICCP []byte
+	// XMP is the XMP packet of the "XMP " chunk.
+	XMP []byte
+}
+
+// ReadMetadata returns the EXIF, ICC profile and XMP chunks of a WEBP image; absent chunks are nil.
+func ReadMetadata(r io.Reader) (Metadata, error) {
+	var m Metadata
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return m, err
+	}
+
+	c, err := parseContainer(data)
+	if err != nil {
+		return m, err
+	}
+
+	for _, ch := range c.chunks {
+		switch ch.fourcc {
+		case "EXIF":
+			m.EXIF = ch.data
+		case "ICCP":
+			m.ICCP = ch.data
+		case "XMP ":
+			m.XMP = ch.data
+		}
+	}
+
+	return m, nil
+}
+
+// SetMetadata copies the WEBP image read from r to w with its metadata chunks replaced by m;
+// nil fields remove the chunk. The image data is copied unchanged, and a simple-format file
+// is converted to the extended format when metadata is added.
+func SetMetadata(w io.Writer, r io.Reader, m Metadata) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c, err := parseContainer(data)
+	if err != nil {
+		return err
+	}
+
+	extended := c.chunks[0].fourcc == "VP8X"
+	if !extended && m.EXIF == nil && m.ICCP == nil && m.XMP == nil {
+		_, err = w.Write(data[:8+binary.LittleEndian.Uint32(data[4:8])])
+		return err
+	}
+
+	vp8x := make([]byte, 10)
+	if extended {
+		copy(vp8x, c.chunks[0].data)
+	} else {
+		store24(vp8x[4:], uint32(c.width-1))
+		store24(vp8x[7:], uint32(c.height-1))
+
+		if hasAlpha(c.chunks[0]) {
+			vp8x[0] |= flagAlpha
+		}
+	}
+
+	vp8x[0] &^= flagICCP | flagEXIF | flagXMP
+
+	var body []byte
+	for _, ch := range c.chunks {
+		switch ch.fourcc {
+		case "VP8X", "ICCP", "EXIF", "XMP ":
+		default:
+			body = appendChunk(body, ch.fourcc, ch.data)
+		}
+	}
+
+	// Chunk order of the extended format: VP8X, ICCP, image data (with ANIM), EXIF, XMP.
+	var out []byte
+	if m.ICCP != nil {
+		vp8x[0] |= flagICCP
+		out = appendChunk(out, "ICCP", m.ICCP)
+	}
+
+	out = append(out, body...)
+
+	if m.EXIF != nil {
+		vp8x[0] |= flagEXIF
+		out = appendChunk(out, "EXIF", m.EXIF)
+	}
+
+	if m.XMP != nil {
+		vp8x[0] |= flagXMP
+		out = appendChunk(out, "XMP ", m.XMP)
+	}
+
+	_, err = w.Write(riffWrap(append(appendChunk(nil, "VP8X", vp8x), out...)))
+
+	return err
+}
+
+// hasAlpha reports whether a simple-format bitstream chunk carries alpha, i.e. a VP8L with alpha_is_used set.
+func hasAlpha(ch chunk) bool {
+	return ch.fourcc == "VP8L" && len(ch.data) >= 5 && binary.LittleEndian.Uint32(ch.data[1:])&(1<<28) != 0
+}
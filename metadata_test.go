@@ -0,0 +1,94 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestSetMetadata(t *testing.T) {
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	want := Metadata{EXIF: exif, ICCP: []byte("icc profile"), XMP: []byte("<x:xmpmeta/>")}
+
+	var lossless bytes.Buffer
+	if err := Encode(&lossless, gradientFrame(16, 8), Options{Lossless: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range [][]byte{testWebp, testWebpAnim, lossless.Bytes()} {
+		var buf bytes.Buffer
+		if err := SetMetadata(&buf, bytes.NewReader(src), want); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got.EXIF, want.EXIF) || !bytes.Equal(got.ICCP, want.ICCP) || !bytes.Equal(got.XMP, want.XMP) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		c, err := parseContainer(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.flags&(flagICCP|flagEXIF|flagXMP) != flagICCP|flagEXIF|flagXMP {
+			t.Errorf("got VP8X flags %#x", c.flags)
+		}
+
+		x, err := DecodeExif(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x.Orientation != 6 {
+			t.Errorf("got orientation %d, want 6", x.Orientation)
+		}
+
+		if _, err := DecodeAll(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		var stripped bytes.Buffer
+		if err := SetMetadata(&stripped, bytes.NewReader(buf.Bytes()), Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err = ReadMetadata(&stripped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.EXIF != nil || got.ICCP != nil || got.XMP != nil {
+			t.Errorf("metadata not stripped: %q", got)
+		}
+	}
+
+	// Alpha of a simple lossless file is carried over to the VP8X flags.
+	img := gradientFrame(8, 8)
+	img.Pix[3] = 0
+
+	var buf, out bytes.Buffer
+	if err := Encode(&buf, img, Options{Lossless: true, Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMetadata(&out, &buf, Metadata{XMP: want.XMP}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := parseContainer(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.flags&flagAlpha == 0 {
+		t.Error("alpha flag not set")
+	}
+
+	m, err := Decode(bytes.NewReader(out.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.(*image.NRGBA).Pix[3] != 0 {
+		t.Error("alpha lost")
+	}
+}
@@ -0,0 +1,158 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// MuxFrame is a still WEBP image placed in an animation by Assemble.
+type MuxFrame struct {
+	// Data is a complete still WEBP file; its bitstream is copied without re-encoding.
+	Data []byte
+	// X and Y are the offset on the canvas; both must be even.
+	X, Y int
+	// Duration is the display time in milliseconds.
+	Duration int
+	// Blend alpha-blends the frame over the previous canvas instead of overwriting it.
+	Blend bool
+	// Dispose clears the frame rectangle to the background after display.
+	Dispose bool
+}
+
+// Assemble writes an animated WEBP built from still WEBP files to w, like webpmux -frame.
+// The canvas is sized to fit every frame; loopCount 0 repeats forever, counts above 65535
+// are clamped to it, and a nil background is opaque white.
+func Assemble(w io.Writer, frames []MuxFrame, loopCount int, background color.Color) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("%w: no frames", ErrEncode)
+	}
+
+	var anmf []byte
+	width, height := 0, 0
+	alpha := false
+
+	for i, f := range frames {
+		if f.X < 0 || f.Y < 0 || f.X%2 != 0 || f.Y%2 != 0 {
+			return fmt.Errorf("%w: frame %d offset %d,%d must be even and non-negative", ErrEncode, i, f.X, f.Y)
+		}
+
+		c, err := parseContainer(f.Data)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+
+		if c.flags&flagAnimation != 0 {
+			return fmt.Errorf("%w: frame %d is an animation", ErrEncode, i)
+		}
+
+		var chunks []byte
+		fw, fh := 0, 0
+
+		for _, ch := range c.chunks {
+			switch ch.fourcc {
+			case "ALPH":
+				alpha = true
+				chunks = appendChunk(chunks, ch.fourcc, ch.data)
+			case "VP8 ", "VP8L":
+				fw, fh, err = bitstreamSize(ch)
+				if err != nil {
+					return fmt.Errorf("frame %d: %w", i, err)
+				}
+
+				alpha = alpha || hasAlpha(ch)
+				chunks = appendChunk(chunks, ch.fourcc, ch.data)
+			}
+		}
+
+		if fw == 0 {
+			return fmt.Errorf("%w: frame %d has no bitstream", ErrEncode, i)
+		}
+
+		width = max(width, f.X+fw)
+		height = max(height, f.Y+fh)
+
+		hdr := make([]byte, 16, 16+len(chunks))
+		store24(hdr[0:], uint32(f.X/2))
+		store24(hdr[3:], uint32(f.Y/2))
+		store24(hdr[6:], uint32(fw-1))
+		store24(hdr[9:], uint32(fh-1))
+		store24(hdr[12:], uint32(f.Duration))
+		if !f.Blend {
+			hdr[15] |= 0x02
+		}
+		if f.Dispose {
+			hdr[15] |= 0x01
+		}
+
+		anmf = appendChunk(anmf, "ANMF", append(hdr, chunks...))
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = flagAnimation
+	if alpha {
+		vp8x[0] |= flagAlpha
+	}
+	store24(vp8x[4:], uint32(width-1))
+	store24(vp8x[7:], uint32(height-1))
+
+	params := make([]byte, 6)
+	binary.LittleEndian.PutUint32(params[0:4], colorToARGB(background))
+	binary.LittleEndian.PutUint16(params[4:6], loopCount16(loopCount))
+
+	data := appendChunk(nil, "VP8X", vp8x)
+	data = appendChunk(data, "ANIM", params)
+	data = append(data, anmf...)
+
+	_, err := w.Write(riffWrap(data))
+
+	return err
+}
+
+// ExtractFrame returns frame i (0-based) of an animated WEBP as a still WEBP file,
+// copying the bitstream without re-encoding. Blending with earlier frames is not applied.
+func ExtractFrame(r io.Reader, i int) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.flags&flagAnimation == 0 {
+		return nil, fmt.Errorf("%w: not an animation", ErrDecode)
+	}
+
+	if i < 0 || i >= len(c.frames) {
+		return nil, fmt.Errorf("%w: frame %d out of range [0,%d)", ErrDecode, i, len(c.frames))
+	}
+
+	bs := c.frames[i].bitstream()
+	if bs == nil {
+		return nil, fmt.Errorf("%w: frame %d has no bitstream", ErrDecode, i)
+	}
+
+	return bs, nil
+}
+
+// SetAnimParams copies the animated WEBP read from r to w with a new loop count and
+// background color (nil is opaque white); the frames are not re-encoded. The loop count is
+// clamped to 65535, as in Assemble.
+func SetAnimParams(w io.Writer, r io.Reader, loopCount int, background color.Color) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if err := setAnimParams(data, colorToARGB(background), loopCount); err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
@@ -0,0 +1,107 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	var frames []MuxFrame
+	var want []*image.NRGBA
+
+	for i, c := range []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		img := solidFrame(8, 6, c).(*image.NRGBA)
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, Options{Lossless: true, Backend: BackendWasm}); err != nil {
+			t.Fatal(err)
+		}
+
+		frames = append(frames, MuxFrame{Data: buf.Bytes(), X: i * 4, Y: i * 2, Duration: 100 * (i + 1)})
+		want = append(want, img)
+	}
+
+	var buf bytes.Buffer
+	if err := Assemble(&buf, frames, 3, color.NRGBA{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := DecodeAll(bytes.NewReader(buf.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := anim.Image[0].Bounds(); b.Dx() != 16 || b.Dy() != 10 {
+		t.Errorf("got canvas %dx%d, want 16x10", b.Dx(), b.Dy())
+	}
+	if anim.LoopCount != 3 || anim.Background != (color.NRGBA{1, 2, 3, 4}) {
+		t.Errorf("got loop %d background %v", anim.LoopCount, anim.Background)
+	}
+
+	for i, img := range anim.Image {
+		if anim.Delay[i] != frames[i].Duration {
+			t.Errorf("frame %d: delay %d, want %d", i, anim.Delay[i], frames[i].Duration)
+		}
+		if c := img.(*image.NRGBA).NRGBAAt(frames[i].X+1, frames[i].Y+1); c != want[i].NRGBAAt(0, 0) {
+			t.Errorf("frame %d: got %v", i, c)
+		}
+
+		// Extracting returns the original bitstream.
+		still, err := ExtractFrame(bytes.NewReader(buf.Bytes()), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(still, frames[i].Data) {
+			t.Errorf("frame %d: extracted bitstream differs", i)
+		}
+	}
+
+	if _, err := ExtractFrame(bytes.NewReader(buf.Bytes()), 3); err == nil {
+		t.Error("frame 3 extracted")
+	}
+
+	var out bytes.Buffer
+	if err := SetAnimParams(&out, bytes.NewReader(buf.Bytes()), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LoopCount != 0 || info.Background != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("got loop %d background %v", info.LoopCount, info.Background)
+	}
+
+	// Loop counts beyond the 16-bit field are clamped, not wrapped to 0 (infinite).
+	out.Reset()
+	if err := SetAnimParams(&out, bytes.NewReader(buf.Bytes()), 0x10000, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err = Inspect(&out); err != nil {
+		t.Fatal(err)
+	}
+	if info.LoopCount != 0xffff {
+		t.Errorf("got loop %d, want 65535", info.LoopCount)
+	}
+
+	out.Reset()
+	if err := Assemble(&out, frames, 70000, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err = Inspect(&out); err != nil {
+		t.Fatal(err)
+	}
+	if info.LoopCount != 0xffff {
+		t.Errorf("got loop %d, want 65535", info.LoopCount)
+	}
+
+	frames[1].X = 3
+	if err := Assemble(&out, frames, 0, nil); err == nil {
+		t.Error("odd offset accepted")
+	}
+}
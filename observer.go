@@ -0,0 +1,149 @@
+package webp
+
+import (
+	"image"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Op names the call a Stats describes.
+type Op int
+
+const (
+	// OpDecode is a Decode call.
+	OpDecode Op = iota
+	// OpDecodeAll is a DecodeAll call.
+	OpDecodeAll
+	// OpDecodeConfig is a DecodeConfig call.
+	OpDecodeConfig
+	// OpEncode is an Encode call.
+	OpEncode
+	// OpEncodeAll is an EncodeAll call.
+	OpEncodeAll
+)
+
+// String returns the name of the operation.
+func (op Op) String() string {
+	switch op {
+	case OpDecode:
+		return "decode"
+	case OpDecodeAll:
+		return "decode_all"
+	case OpDecodeConfig:
+		return "decode_config"
+	case OpEncode:
+		return "encode"
+	case OpEncodeAll:
+		return "encode_all"
+	}
+
+	return "unknown"
+}
+
+// Stats describes one finished decode or encode call.
+type Stats struct {
+	// Op is the operation.
+	Op Op
+	// Backend is the backend that served the call, BackendDynamic or BackendWasm. If the call
+	// failed before one was chosen, e.g. on a nil image or an unavailable library, it is the one
+	// requested, which may be BackendAuto.
+	Backend Backend
+	// Duration is the wall time of the call.
+	Duration time.Duration
+	// Bytes is the size of the WEBP data, read by a decode or written by an encode.
+	Bytes int64
+	// Pixels is the number of pixels decoded or encoded, over all frames.
+	Pixels int64
+	// Frames is the number of frames decoded or encoded.
+	Frames int
+	// Err is the error returned by the call, nil on success.
+	Err error
+}
+
+// Observer receives the Stats of each call, e.g. to export latency and size metrics. It is
+// called synchronously on the calling goroutine, so it should be quick and must be safe for
+// concurrent use.
+type Observer interface {
+	Observe(s Stats)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(s Stats)
+
+// Observe calls f(s).
+func (f ObserverFunc) Observe(s Stats) {
+	f(s)
+}
+
+var defaultObserver atomic.Pointer[Observer]
+
+// SetObserver sets the process-wide observer of calls whose Options leave Observer nil;
+// nil disables it.
+func SetObserver(o Observer) {
+	if o == nil {
+		defaultObserver.Store(nil)
+		return
+	}
+
+	defaultObserver.Store(&o)
+}
+
+// observer returns the observer of a call made with o, nil if there is none.
+func (o Options) observer() Observer {
+	if o.Observer != nil {
+		return o.Observer
+	}
+
+	if p := defaultObserver.Load(); p != nil {
+		return *p
+	}
+
+	return nil
+}
+
+// decodeStats fills the pixel and frame counts of s from a decode result.
+func decodeStats(s Stats, ret *WEBP) Stats {
+	if ret == nil {
+		return s
+	}
+
+	s.Frames = len(ret.Image)
+	for _, img := range ret.Image {
+		b := img.Bounds()
+		s.Pixels += int64(b.Dx()) * int64(b.Dy())
+	}
+
+	return s
+}
+
+// pixels returns the area of r.
+func pixels(r image.Rectangle) int64 {
+	return int64(r.Dx()) * int64(r.Dy())
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
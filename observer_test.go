@@ -0,0 +1,158 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	stats []Stats
+}
+
+func (r *recorder) Observe(s Stats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats = append(r.stats, s)
+}
+
+func TestObserver(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i) | 0x80
+	}
+
+	rec := &recorder{}
+	opt := Options{Backend: BackendWasm, Observer: rec}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Identical frames would be merged by the encoder.
+	anim := &WEBP{Delay: []int{10, 10, 10}}
+	for i := 0; i < 3; i++ {
+		frame := image.NewGray(img.Rect)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i * 100)
+		}
+		anim.Image = append(anim.Image, frame)
+	}
+
+	var out bytes.Buffer
+	if err := EncodeAll(&out, anim, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeAll(bytes.NewReader(out.Bytes()), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBPjunk")), opt); err == nil {
+		t.Fatal("decoded junk")
+	}
+
+	want := []Stats{
+		{Op: OpEncode, Bytes: int64(buf.Len()), Pixels: 128, Frames: 1},
+		{Op: OpDecode, Bytes: int64(buf.Len()), Pixels: 128, Frames: 1},
+		{Op: OpEncodeAll, Bytes: int64(out.Len()), Pixels: 384, Frames: 3},
+		{Op: OpDecodeAll, Bytes: int64(out.Len()), Pixels: 384, Frames: 3},
+		{Op: OpDecode, Bytes: 16},
+	}
+
+	if len(rec.stats) != len(want) {
+		t.Fatalf("got %d stats, want %d", len(rec.stats), len(want))
+	}
+
+	for i, s := range rec.stats {
+		w := want[i]
+		if s.Op != w.Op || s.Backend != BackendWasm || s.Bytes != w.Bytes || s.Pixels != w.Pixels || s.Frames != w.Frames {
+			t.Errorf("%d: got %+v, want %+v", i, s, w)
+		}
+
+		if s.Duration <= 0 {
+			t.Errorf("%d: got duration %v", i, s.Duration)
+		}
+
+		if (s.Err != nil) != (i == len(want)-1) {
+			t.Errorf("%d: got error %v", i, s.Err)
+		}
+	}
+}
+
+func TestObserverEncodeNil(t *testing.T) {
+	var got Stats
+	err := Encode(io.Discard, nil, Options{Observer: ObserverFunc(func(s Stats) { got = s })})
+	if !errors.Is(err, ErrEncode) {
+		t.Fatalf("got %v, want ErrEncode", err)
+	}
+
+	if got.Op != OpEncode || got.Backend != BackendAuto || got.Pixels != 0 || got.Frames != 0 || got.Err != err {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSetObserver(t *testing.T) {
+	var ops []Op
+	SetObserver(ObserverFunc(func(s Stats) { ops = append(ops, s.Op) }))
+	defer SetObserver(nil)
+
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	img.SetGray(1, 1, color.Gray{Y: 200})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, Options{Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeConfig(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// A per-call observer replaces the default one.
+	rec := &recorder{}
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), Options{Backend: BackendWasm, Observer: rec}); err != nil {
+		t.Fatal(err)
+	}
+
+	SetObserver(nil)
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), Options{Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 2 || ops[0] != OpEncode || ops[1] != OpDecodeConfig {
+		t.Errorf("got %v, want [encode decode_config]", ops)
+	}
+
+	if len(rec.stats) != 1 || rec.stats[0].Op != OpDecode {
+		t.Errorf("got %+v from the per-call observer", rec.stats)
+	}
+}
+
+func TestObserverDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		t.Skip(err)
+	}
+
+	var got Stats
+	opt := Options{Backend: BackendDynamic, Observer: ObserverFunc(func(s Stats) { got = s })}
+
+	if _, err := Decode(bytes.NewReader(testWebp), opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Op != OpDecode || got.Backend != BackendDynamic || got.Bytes != int64(len(testWebp)) || got.Pixels == 0 {
+		t.Errorf("got %+v", got)
+	}
+}
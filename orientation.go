@@ -0,0 +1,293 @@
+package webp
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Orient returns img rotated and/or flipped as the EXIF orientation (1-8) prescribes, so it displays
+// upright. Orientation 1 and values out of range return img unchanged.
+//
+// The result has the type of img, with its bounds at the origin, for *image.YCbCr and
+// *image.NYCbCrA (4:4:4, 4:2:2, 4:2:0 and 4:4:0; 4:2:2 and 4:4:0 swap when transposed),
+// *image.RGBA, *image.NRGBA, *image.RGBA64, *image.NRGBA64, *image.Gray, *image.Gray16,
+// *image.Alpha, *image.Alpha16, *image.CMYK and *image.Paletted; pixels are moved, not converted.
+// Subsampled chroma is moved per block, so a flip of an odd-sized side shifts the chroma by half a
+// pixel. Other images, and subsampled sub-images at an odd offset, are first drawn onto an image
+// of their color model, *image.RGBA if unknown.
+func Orient(img image.Image, orientation int) image.Image {
+	return applyOrientation(img, orientation)
+}
+
+// applyOrientation returns img rotated/flipped per the EXIF orientation (unchanged for 1 or out of range).
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	dr := image.Rect(0, 0, b.Dx(), b.Dy())
+	if orientation >= 5 {
+		dr = image.Rect(0, 0, b.Dy(), b.Dx()) // 90/270 rotations swap width and height
+	}
+
+	switch src := img.(type) {
+	case *image.NYCbCrA:
+		if dst := orientYCbCr(&src.YCbCr, orientation); dst != nil {
+			n := &image.NYCbCrA{YCbCr: *dst, A: make([]byte, dr.Dx()*dr.Dy()), AStride: dr.Dx()}
+			orientPlane(n.A, src.A[src.AOffset(b.Min.X, b.Min.Y):], n.AStride, src.AStride, 1, b.Dx(), b.Dy(), orientation)
+			return n
+		}
+	case *image.YCbCr:
+		if dst := orientYCbCr(src, orientation); dst != nil {
+			return dst
+		}
+	case *image.RGBA:
+		dst := image.NewRGBA(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 8, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 8, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.Gray:
+		dst := image.NewGray(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 2, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.Alpha16:
+		dst := image.NewAlpha16(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 2, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(dr)
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 4, b.Dx(), b.Dy(), orientation)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(dr, append(color.Palette(nil), src.Palette...))
+		orientPlane(dst.Pix, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], dst.Stride, src.Stride, 1, b.Dx(), b.Dy(), orientation)
+		return dst
+	}
+
+	// Draw onto a concrete image first, which draw does with its own fast paths, and orient that.
+	var tmp draw.Image
+	switch img.ColorModel() {
+	case color.NRGBAModel:
+		tmp = image.NewNRGBA(b)
+	case color.RGBA64Model:
+		tmp = image.NewRGBA64(b)
+	case color.NRGBA64Model:
+		tmp = image.NewNRGBA64(b)
+	case color.GrayModel:
+		tmp = image.NewGray(b)
+	case color.Gray16Model:
+		tmp = image.NewGray16(b)
+	case color.AlphaModel:
+		tmp = image.NewAlpha(b)
+	case color.Alpha16Model:
+		tmp = image.NewAlpha16(b)
+	case color.CMYKModel:
+		tmp = image.NewCMYK(b)
+	default:
+		tmp = image.NewRGBA(b)
+	}
+
+	draw.Draw(tmp, b, img, b.Min, draw.Src)
+
+	return applyOrientation(tmp, orientation)
+}
+
+// encodeOrientation returns the orientation Encode/EncodeAll apply to the pixels and the EXIF payload
+// the output carries (nil for none), per the Orientation, TagOrientation and ExifData options.
+func (o Options) encodeOrientation() (int, []byte, error) {
+	if o.Orientation == 0 {
+		return 1, o.ExifData, nil
+	}
+
+	bake, tag := o.Orientation, 1
+	if o.TagOrientation {
+		bake, tag = 1, o.Orientation
+	}
+
+	exif, err := SetExifOrientation(o.ExifData, tag)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return bake, exif, nil
+}
+
+// Rotate90 returns img rotated 90 degrees clockwise (EXIF orientation 6).
+func Rotate90(img image.Image) image.Image {
+	return Orient(img, 6)
+}
+
+// Rotate180 returns img rotated 180 degrees (EXIF orientation 3).
+func Rotate180(img image.Image) image.Image {
+	return Orient(img, 3)
+}
+
+// Rotate270 returns img rotated 270 degrees clockwise (EXIF orientation 8).
+func Rotate270(img image.Image) image.Image {
+	return Orient(img, 8)
+}
+
+// FlipHorizontal returns img mirrored left to right (EXIF orientation 2).
+func FlipHorizontal(img image.Image) image.Image {
+	return Orient(img, 2)
+}
+
+// FlipVertical returns img mirrored top to bottom (EXIF orientation 4).
+func FlipVertical(img image.Image) image.Image {
+	return Orient(img, 4)
+}
+
+// Transpose returns img mirrored along its top-left to bottom-right diagonal (EXIF orientation 5).
+func Transpose(img image.Image) image.Image {
+	return Orient(img, 5)
+}
+
+// Transverse returns img mirrored along its top-right to bottom-left diagonal (EXIF orientation 7).
+func Transverse(img image.Image) image.Image {
+	return Orient(img, 7)
+}
+
+// orientTarget maps a source pixel to its destination for the given EXIF orientation.
+func orientTarget(orientation, sx, sy, sw, sh int) (int, int) {
+	switch orientation {
+	case 2: // flip horizontal
+		return sw - 1 - sx, sy
+	case 3: // rotate 180
+		return sw - 1 - sx, sh - 1 - sy
+	case 4: // flip vertical
+		return sx, sh - 1 - sy
+	case 5: // transpose
+		return sy, sx
+	case 6: // rotate 90 CW
+		return sh - 1 - sy, sx
+	case 7: // transverse
+		return sh - 1 - sy, sw - 1 - sx
+	case 8: // rotate 270 CW
+		return sy, sw - 1 - sx
+	}
+
+	return sx, sy
+}
+
+// orientPlane moves the sw x sh pixels of bpp bytes from src to dst. orientTarget is affine, so
+// the destination offset advances by a fixed step per source column and row.
+func orientPlane(dst, src []byte, dstStride, srcStride, bpp, sw, sh, orientation int) {
+	offset := func(sx, sy int) int {
+		x, y := orientTarget(orientation, sx, sy, sw, sh)
+		return y*dstStride + x*bpp
+	}
+
+	base := offset(0, 0)
+	stepX := offset(1, 0) - base
+	stepY := offset(0, 1) - base
+
+	for sy := 0; sy < sh; sy++ {
+		srow := src[sy*srcStride : sy*srcStride+sw*bpp]
+		d := base + sy*stepY
+
+		switch bpp {
+		case 1:
+			for _, v := range srow {
+				dst[d] = v
+				d += stepX
+			}
+		case 4:
+			for s := 0; s < len(srow); s += 4 {
+				p := dst[d : d+4 : d+4]
+				p[0], p[1], p[2], p[3] = srow[s], srow[s+1], srow[s+2], srow[s+3]
+				d += stepX
+			}
+		default:
+			for s := 0; s < len(srow); s += bpp {
+				copy(dst[d:d+bpp], srow[s:s+bpp])
+				d += stepX
+			}
+		}
+	}
+}
+
+// chromaSize returns the size of the chroma planes of r, or false for unsupported subsampling.
+func chromaSize(r image.Rectangle, ratio image.YCbCrSubsampleRatio) (int, int, bool) {
+	w, h := r.Dx(), r.Dy()
+	hw := (r.Max.X+1)/2 - r.Min.X/2
+	hh := (r.Max.Y+1)/2 - r.Min.Y/2
+
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return w, h, true
+	case image.YCbCrSubsampleRatio422:
+		return hw, h, true
+	case image.YCbCrSubsampleRatio420:
+		return hw, hh, true
+	case image.YCbCrSubsampleRatio440:
+		return w, hh, true
+	}
+
+	return 0, 0, false
+}
+
+// orientYCbCr orients the luma and chroma planes of src, or returns nil when its subsampling
+// cannot be moved per plane.
+func orientYCbCr(src *image.YCbCr, orientation int) *image.YCbCr {
+	b := src.Rect
+	sw, sh := b.Dx(), b.Dy()
+
+	ratio := src.SubsampleRatio
+	dr := image.Rect(0, 0, sw, sh)
+	if orientation >= 5 {
+		dr = image.Rect(0, 0, sh, sw)
+
+		switch ratio {
+		case image.YCbCrSubsampleRatio422:
+			ratio = image.YCbCrSubsampleRatio440
+		case image.YCbCrSubsampleRatio440:
+			ratio = image.YCbCrSubsampleRatio422
+		}
+	}
+
+	scw, sch, ok := chromaSize(b, src.SubsampleRatio)
+	if !ok {
+		return nil
+	}
+
+	dcw, dch, _ := chromaSize(dr, ratio)
+	if orientation >= 5 {
+		dcw, dch = dch, dcw
+	}
+
+	// A sub-image at an odd offset has chroma planes of another size than at the origin.
+	if scw != dcw || sch != dch {
+		return nil
+	}
+
+	dst := image.NewYCbCr(dr, ratio)
+
+	orientPlane(dst.Y, src.Y[src.YOffset(b.Min.X, b.Min.Y):], dst.YStride, src.YStride, 1, sw, sh, orientation)
+
+	co := src.COffset(b.Min.X, b.Min.Y)
+	orientPlane(dst.Cb, src.Cb[co:], dst.CStride, src.CStride, 1, scw, sch, orientation)
+	orientPlane(dst.Cr, src.Cr[co:], dst.CStride, src.CStride, 1, scw, sch, orientation)
+
+	return dst
+}
@@ -1,7 +1,11 @@
 package webp
 
 import (
+	"bytes"
+	"fmt"
 	"image"
+	"image/color"
+	"os"
 	"testing"
 )
 
@@ -31,3 +35,135 @@ func TestApplyOrientationFastPath(t *testing.T) {
 		}
 	}
 }
+
+// checkOriented compares every pixel of dst with the source pixel orientTarget maps to it.
+func checkOriented(t *testing.T, name string, src, dst image.Image, o int) {
+	t.Helper()
+
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	want := image.Rect(0, 0, sw, sh)
+	if o >= 5 {
+		want = image.Rect(0, 0, sh, sw)
+	}
+	if dst.Bounds() != want {
+		t.Fatalf("%s orientation %d: bounds %v, want %v", name, o, dst.Bounds(), want)
+	}
+
+	for sy := 0; sy < sh; sy++ {
+		for sx := 0; sx < sw; sx++ {
+			dx, dy := orientTarget(o, sx, sy, sw, sh)
+			if got, want := dst.At(dx, dy), src.At(b.Min.X+sx, b.Min.Y+sy); got != want {
+				t.Fatalf("%s orientation %d: pixel (%d,%d) = %v, want %v", name, o, sx, sy, got, want)
+			}
+		}
+	}
+}
+
+func TestOrientPreservesType(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	gray := image.NewGray(image.Rect(0, 0, 6, 4))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = byte(i * 7)
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i * 11)
+	}
+
+	images := map[string]image.Image{
+		"NRGBA":     nrgba,
+		"Gray":      gray,
+		"sub-NRGBA": nrgba.SubImage(image.Rect(1, 1, 6, 4)),
+		"Paletted": &image.Paletted{
+			Pix: []byte{0, 1, 2, 1, 0, 2}, Stride: 3, Rect: image.Rect(0, 0, 3, 2),
+			Palette: color.Palette{color.Black, color.White, color.NRGBA{255, 0, 0, 128}},
+		},
+	}
+
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio440,
+	} {
+		img := image.NewNYCbCrA(image.Rect(0, 0, 6, 4), ratio)
+		for i := range img.Y {
+			img.Y[i] = byte(i * 9)
+			img.A[i] = byte(255 - i)
+		}
+		for i := range img.Cb {
+			img.Cb[i] = byte(i * 13)
+			img.Cr[i] = byte(200 - i*5)
+		}
+
+		images["NYCbCrA-"+ratio.String()] = img
+		images["YCbCr-"+ratio.String()] = &img.YCbCr
+		images["sub-YCbCr-"+ratio.String()] = img.YCbCr.SubImage(image.Rect(2, 2, 6, 4))
+	}
+
+	for name, src := range images {
+		for o := 2; o <= 8; o++ {
+			dst := Orient(src, o)
+
+			if got, want := fmt.Sprintf("%T", dst), fmt.Sprintf("%T", src); got != want {
+				t.Errorf("%s orientation %d: got %s, want %s", name, o, got, want)
+			}
+			if s, ok := src.(*image.YCbCr); ok && o < 5 && dst.(*image.YCbCr).SubsampleRatio != s.SubsampleRatio {
+				t.Errorf("%s orientation %d: subsample ratio changed", name, o)
+			}
+
+			checkOriented(t, name, src, dst, o)
+		}
+	}
+}
+
+func TestOrientHelpers(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	copy(src.Pix, []byte{1, 2, 3, 4})
+
+	for _, tc := range []struct {
+		name string
+		f    func(image.Image) image.Image
+		want []byte
+	}{
+		{"Rotate90", Rotate90, []byte{3, 1, 4, 2}},
+		{"Rotate180", Rotate180, []byte{4, 3, 2, 1}},
+		{"Rotate270", Rotate270, []byte{2, 4, 1, 3}},
+		{"FlipHorizontal", FlipHorizontal, []byte{2, 1, 4, 3}},
+		{"FlipVertical", FlipVertical, []byte{3, 4, 1, 2}},
+		{"Transpose", Transpose, []byte{1, 3, 2, 4}},
+		{"Transverse", Transverse, []byte{4, 2, 3, 1}},
+	} {
+		if got := tc.f(src).(*image.Gray).Pix; !bytes.Equal(got, tc.want) {
+			t.Errorf("%s = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if Orient(src, 1) != image.Image(src) || Orient(src, 9) != image.Image(src) {
+		t.Error("orientation 1 or out of range changed the image")
+	}
+}
+
+func TestDecodeAutoRotateType(t *testing.T) {
+	data, err := os.ReadFile("testdata/exif.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []ColorMode{ModeDefault, ModeNRGBA, ModeGray} {
+		plain, err := Decode(bytes.NewReader(data), Options{ColorMode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rot, err := Decode(bytes.NewReader(data), Options{ColorMode: mode, AutoRotate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rot.ColorModel() != plain.ColorModel() {
+			t.Errorf("mode %v: auto-rotate changed the color model", mode)
+		}
+
+		checkOriented(t, "decode", plain, rot, 6)
+	}
+}
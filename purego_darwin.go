@@ -11,6 +11,7 @@ import (
 const (
 	libname      = "libwebp.dylib"
 	libnameDemux = "libwebpdemux.dylib"
+	libnameMux   = "libwebpmux.dylib"
 )
 
 func loadLibrary(name string) (uintptr, error) {
@@ -11,16 +11,27 @@ import (
 var (
 	dynamic    = false
 	dynamicErr = fmt.Errorf("webp: dynamic disabled")
+
+	dynamicMux    = false
+	dynamicMuxErr = dynamicErr
 )
 
-func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error) {
+func decodeDynamic(r io.Reader, configOnly, decodeAll bool, mode ColorMode, threads int) (*WEBP, image.Config, error) {
 	return nil, image.Config{}, dynamicErr
 }
 
-func encodeDynamic(w io.Writer, m image.Image, quality, method int, lossless, exact bool) error {
+func encodeDynamic(w io.Writer, m image.Image, quality, method int, lossless, exact bool, threads int) error {
 	return dynamicErr
 }
 
+func encodeAnimationDynamic(frames []byte, width, height, count int, delays []int, anim animOptions, configs []frameConfig, threads int) ([]byte, error) {
+	return nil, dynamicMuxErr
+}
+
+func versionDynamic() (decoder, encoder int) {
+	return 0, 0
+}
+
 func loadLibrary(name string) (uintptr, error) {
 	return 0, dynamicErr
 }
@@ -14,6 +14,7 @@ import (
 const (
 	libname      = "libwebp.so"
 	libnameDemux = "libwebpdemux.so"
+	libnameMux   = "libwebpmux.so"
 )
 
 func loadLibrary(name string) (uintptr, error) {
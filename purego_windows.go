@@ -10,6 +10,7 @@ import (
 const (
 	libname      = "libwebp.dll"
 	libnameDemux = "libwebpdemux.dll"
+	libnameMux   = "libwebpmux.dll"
 )
 
 func loadLibrary(name string) (uintptr, error) {
@@ -6,22 +6,29 @@ package webp
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
 	"io"
+	"time"
 )
 
 // Errors .
 var (
 	ErrMemRead  = errors.New("webp: mem read failed")
 	ErrMemWrite = errors.New("webp: mem write failed")
+	ErrMemAlloc = errors.New("webp: mem alloc failed")
 	ErrDecode   = errors.New("webp: decode failed")
 	ErrEncode   = errors.New("webp: encode failed")
+	ErrLimit    = errors.New("webp: image exceeds decode limits")
+	ErrTrap     = errors.New("webp: wasm trap")
 )
 
 const (
 	webpMaxHeaderSize     = 32
 	webpDemuxABIVersion   = 0x0107
+	webpMuxABIVersion     = 0x0109
 	webpDecoderABIVersion = 0x0209
 	webpEncoderABIVersion = 0x020f
 )
@@ -32,8 +39,39 @@ type WEBP struct {
 	Image []image.Image
 	// Delay times, one per frame, in milliseconds.
 	Delay []int
-	// LoopCount is the number of times the animation repeats (0 = infinite).
+	// LoopCount is the number of times the animation repeats (0 = infinite). EncodeAll clamps it
+	// to the 16-bit range of the format, 0..65535.
 	LoopCount int
+	// Background is the canvas background color hint of an animation (nil = opaque white on encode).
+	Background color.Color
+	// Config is the canvas of an animation; ColorModel is ignored by EncodeAll. A zero size lets
+	// EncodeAll take the first frame's size, or the extent of all frames if their sizes differ.
+	// DecodeAll leaves it zero, as every frame it returns covers the whole canvas, so frames
+	// resized after DecodeAll are encoded on a canvas of their new size.
+	Config image.Config
+	// Offset optionally positions each frame on the canvas; frames without one are placed
+	// at their bounds offset, as image/gif does. The first frame is drawn on a transparent
+	// canvas. A later frame that covers the whole canvas replaces it; a smaller one is shown
+	// the way a WEBP frame with blending and no dispose is, composited over the canvas of the
+	// frame before it.
+	Offset []image.Point
+	// Options optionally overrides the encoding settings per frame, see FrameOptions; nil
+	// entries and missing frames use the options passed to EncodeAll.
+	Options []*FrameOptions
+}
+
+// FrameOptions override the encoding settings of one EncodeAll frame. Fields left at their
+// zero value inherit the setting from the Options of the EncodeAll call; Method, Lossless and
+// Exact are pointers because their zero values are valid settings of their own.
+type FrameOptions struct {
+	// Quality in the range [1,100]; 0 inherits.
+	Quality int
+	// Method in the range [0,6]; nil inherits.
+	Method *int
+	// Lossless replaces the call's Lossless; nil inherits.
+	Lossless *bool
+	// Exact replaces the call's Exact; nil inherits.
+	Exact *bool
 }
 
 // DefaultQuality is the default quality encoding parameter.
@@ -42,7 +80,7 @@ const DefaultQuality = 75
 // DefaultMethod is the default method encoding parameter.
 const DefaultMethod = 4
 
-// Options are the encoding parameters, plus AutoRotate which applies to Decode.
+// Options are the encoding parameters, plus AutoRotate and ColorMode which apply to Decode.
 type Options struct {
 	// Quality in the range [0,100]. Default is 75.
 	Quality int
@@ -54,26 +92,97 @@ type Options struct {
 	Exact bool
 	// AutoRotate applies the EXIF orientation to the decoded image (Decode/DecodeAll only).
 	AutoRotate bool
+	// ColorMode selects the pixel format of decoded images (Decode/DecodeAll only).
+	ColorMode ColorMode
+	// Backend selects the libwebp implementation. Default is BackendAuto.
+	Backend Backend
+	// Threads is the number of threads/goroutines a call may use: 0 keeps the backend default
+	// (libwebp threads on the dynamic backend, sequential wasm), 1 disables threading, and
+	// larger values also let DecodeAll on the wasm backend decode that many frames concurrently.
+	Threads int
+	// Limits bound the dimensions, frame count and decoded size accepted by Decode/DecodeAll.
+	Limits Limits
+	// Kmin, Kmax, MinimizeSize and AllowMixed only apply to EncodeAll; Encode ignores them. On
+	// the wasm backend, setting any of them (or per-frame options) switches from libwebp's
+	// animation encoder to a Go one that encodes each changed frame two to four times, as a
+	// sub-rectangle, as a key frame and, for AllowMixed, both lossy and lossless, and keeps
+	// the smallest; expect EncodeAll to take that much longer, see BenchmarkEncodeAllWasm2go.
+
+	// Kmin and Kmax are the minimum and maximum distance between key frames.
+	// Kmax 0 disables key frames, as in libwebp, and Kmax 1 makes every frame a key frame.
+	Kmin, Kmax int
+	// MinimizeSize picks the smallest frame encoding at the cost of speed; it disables key frames.
+	MinimizeSize bool
+	// AllowMixed chooses lossy or lossless per frame, whichever is smaller.
+	AllowMixed bool
+	// Orientation is the EXIF orientation (1-8) of the image given to Encode/EncodeAll, 0 if unknown.
+	// It is applied to the pixels (see Orient) and the output carries EXIF with Orientation 1.
+	Orientation int
+	// TagOrientation leaves the pixels alone and writes Orientation to the EXIF Orientation tag instead.
+	TagOrientation bool
+	// ExifData is a TIFF/EXIF payload attached to the output of Encode/EncodeAll, e.g. that of the
+	// source image; when Orientation is set, its Orientation tag is rewritten, see SetExifOrientation.
+	ExifData []byte
+	// Observer receives the Stats of the call, overriding the process default set by SetObserver.
+	Observer Observer
 }
 
 // decodeWEBP dispatches to the dynamic (system libwebp) or wasm backend.
-func decodeWEBP(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error) {
-	if dynamic {
-		return decodeDynamic(r, configOnly, decodeAll)
+func decodeWEBP(r io.Reader, configOnly, decodeAll bool, o Options) (ret *WEBP, cfg image.Config, err error) {
+	var backend Backend
+
+	if obs := o.observer(); obs != nil {
+		op := OpDecode
+		if configOnly {
+			op = OpDecodeConfig
+		} else if decodeAll {
+			op = OpDecodeAll
+		}
+
+		cr := &countingReader{r: r}
+		r = cr
+
+		start := time.Now()
+		defer func() {
+			obs.Observe(decodeStats(Stats{Op: op, Backend: backend, Duration: time.Since(start), Bytes: cr.n, Err: err}, ret))
+		}()
 	}
 
-	return decode(r, configOnly, decodeAll)
+	backend, err = resolveBackend(o.Backend)
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+
+	if o.Limits != (Limits{}) {
+		data, err := o.Limits.read(r, configOnly, decodeAll, o.ColorMode)
+		if err != nil {
+			return nil, image.Config{}, err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	if backend == BackendDynamic {
+		return decodeDynamic(r, configOnly, decodeAll, o.ColorMode, o.Threads)
+	}
+
+	return decode(r, configOnly, decodeAll, o.ColorMode, o.Threads)
 }
 
 // Decode reads a WEBP image from r; pass Options{AutoRotate: true} to apply the EXIF orientation.
 func Decode(r io.Reader, opts ...Options) (image.Image, error) {
-	if len(opts) > 0 && opts[0].AutoRotate {
-		data, err := io.ReadAll(r)
+	var opt Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.AutoRotate {
+		data, err := opt.readAll(r, false)
 		if err != nil {
 			return nil, err
 		}
 
-		ret, _, err := decodeWEBP(bytes.NewReader(data), false, false)
+		ret, _, err := decodeWEBP(bytes.NewReader(data), false, false, opt)
 		if err != nil {
 			return nil, err
 		}
@@ -81,7 +190,7 @@ func Decode(r io.Reader, opts ...Options) (image.Image, error) {
 		return applyOrientation(ret.Image[0], exifOrientation(data)), nil
 	}
 
-	ret, _, err := decodeWEBP(r, false, false)
+	ret, _, err := decodeWEBP(r, false, false, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +200,7 @@ func Decode(r io.Reader, opts ...Options) (image.Image, error) {
 
 // DecodeConfig returns the color model and dimensions of a WEBP image without decoding the entire image.
 func DecodeConfig(r io.Reader) (image.Config, error) {
-	_, cfg, err := decodeWEBP(r, true, false)
+	_, cfg, err := decodeWEBP(r, true, false, Options{})
 	if err != nil {
 		return image.Config{}, err
 	}
@@ -100,14 +209,20 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 }
 
 // DecodeAll returns the sequential frames and timing; pass Options{AutoRotate: true} to orient each frame.
+// Every frame is composited onto the full canvas, whose size is the frame size; Config is left zero.
 func DecodeAll(r io.Reader, opts ...Options) (*WEBP, error) {
-	if len(opts) > 0 && opts[0].AutoRotate {
-		data, err := io.ReadAll(r)
+	var opt Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.AutoRotate {
+		data, err := opt.readAll(r, true)
 		if err != nil {
 			return nil, err
 		}
 
-		ret, _, err := decodeWEBP(bytes.NewReader(data), false, true)
+		ret, _, err := decodeWEBP(bytes.NewReader(data), false, true, opt)
 		if err != nil {
 			return nil, err
 		}
@@ -120,7 +235,7 @@ func DecodeAll(r io.Reader, opts ...Options) (*WEBP, error) {
 		return ret, nil
 	}
 
-	ret, _, err := decodeWEBP(r, false, true)
+	ret, _, err := decodeWEBP(r, false, true, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -128,12 +243,34 @@ func DecodeAll(r io.Reader, opts ...Options) (*WEBP, error) {
 	return ret, nil
 }
 
-// Encode writes the image m to w with the given options.
-func Encode(w io.Writer, m image.Image, o ...Options) error {
+// Encode writes the image m to w with the given options. A nil m returns ErrEncode.
+func Encode(w io.Writer, m image.Image, o ...Options) (err error) {
 	lossless := false
 	quality := DefaultQuality
 	method := DefaultMethod
 	exact := false
+	backend := BackendAuto
+	threads := 0
+
+	var exif []byte
+
+	if obs := observerOf(o); obs != nil {
+		cw := &countingWriter{w: w}
+		w = cw
+
+		start := time.Now()
+		defer func() {
+			s := Stats{Op: OpEncode, Backend: backend, Duration: time.Since(start), Bytes: cw.n, Err: err}
+			if m != nil {
+				s.Pixels, s.Frames = pixels(m.Bounds()), 1
+			}
+			obs.Observe(s)
+		}()
+	}
+
+	if m == nil {
+		return ErrEncode
+	}
 
 	if o != nil {
 		opt := o[0]
@@ -141,6 +278,8 @@ func Encode(w io.Writer, m image.Image, o ...Options) error {
 		quality = opt.Quality
 		method = opt.Method
 		exact = opt.Exact
+		backend = opt.Backend
+		threads = opt.Threads
 
 		if quality <= 0 {
 			quality = DefaultQuality
@@ -153,85 +292,201 @@ func Encode(w io.Writer, m image.Image, o ...Options) error {
 		} else if method > 6 {
 			method = 6
 		}
+
+		orientation, data, err := opt.encodeOrientation()
+		if err != nil {
+			return err
+		}
+
+		m = applyOrientation(m, orientation)
+		exif = data
 	}
 
-	if dynamic {
-		err := encodeDynamic(w, m, quality, method, lossless, exact)
+	backend, err = resolveBackend(backend)
+	if err != nil {
+		return err
+	}
+
+	// With EXIF the image is encoded to a buffer first, then copied with the chunk added.
+	out := w
+	var buf bytes.Buffer
+	if exif != nil {
+		out = &buf
+	}
+
+	if backend == BackendDynamic {
+		err := encodeDynamic(out, m, quality, method, lossless, exact, threads)
 		if err != nil {
 			return err
 		}
 	} else {
-		err := encode(w, m, quality, method, lossless, exact)
+		err := encode(out, m, quality, method, lossless, exact)
 		if err != nil {
 			return err
 		}
 	}
 
+	if exif != nil {
+		return SetMetadata(w, &buf, Metadata{EXIF: exif})
+	}
+
 	return nil
 }
 
-// EncodeAll writes the animation anim to w; all frames must share the same bounds.
-func EncodeAll(w io.Writer, anim *WEBP, o ...Options) error {
+// EncodeAll writes the animation anim to w. Frames of different sizes are placed on the canvas
+// given by anim.Config, see WEBP.
+func EncodeAll(w io.Writer, anim *WEBP, o ...Options) (err error) {
+	backend := BackendAuto
+
+	if obs := observerOf(o); obs != nil {
+		cw := &countingWriter{w: w}
+		w = cw
+
+		var s Stats
+		if anim != nil {
+			s.Frames = len(anim.Image)
+			for _, img := range anim.Image {
+				s.Pixels += pixels(img.Bounds())
+			}
+		}
+
+		start := time.Now()
+		defer func() {
+			s.Op, s.Backend, s.Duration, s.Bytes, s.Err = OpEncodeAll, backend, time.Since(start), cw.n, err
+			obs.Observe(s)
+		}()
+	}
+
 	if anim == nil || len(anim.Image) == 0 {
 		return ErrEncode
 	}
 
-	lossless := false
-	quality := DefaultQuality
-	method := DefaultMethod
-	exact := false
+	config := frameConfig{quality: DefaultQuality, method: DefaultMethod}
+	threads := 0
+
+	params := animOptions{loopCount: int(loopCount16(anim.LoopCount)), bgcolor: colorToARGB(anim.Background)}
+
+	orientation := 1
+	var exif []byte
 
 	if o != nil {
 		opt := o[0]
-		lossless = opt.Lossless
-		quality = opt.Quality
-		method = opt.Method
-		exact = opt.Exact
+		config = frameConfigOf(opt)
+		backend = opt.Backend
+		threads = opt.Threads
 
-		if quality <= 0 {
-			quality = DefaultQuality
-		} else if quality > 100 {
-			quality = 100
+		var err error
+		if orientation, exif, err = opt.encodeOrientation(); err != nil {
+			return err
 		}
 
-		if method < 0 {
-			method = DefaultMethod
-		} else if method > 6 {
-			method = 6
-		}
+		params.kmin = opt.Kmin
+		params.kmax = opt.Kmax
+		params.minimizeSize = opt.MinimizeSize
+		params.allowMixed = opt.AllowMixed
 	}
 
-	b := anim.Image[0].Bounds()
-	width, height := b.Dx(), b.Dy()
+	backend, err = resolveMuxBackend(backend)
+	if err != nil {
+		return err
+	}
+
+	width, height, placed := anim.canvas()
 	frameSize := width * height * 4
 
 	frames := make([]byte, frameSize*len(anim.Image))
+	images := make([]*image.NRGBA, len(anim.Image))
 	delays := make([]int, len(anim.Image))
+	configs := make([]frameConfig, len(anim.Image))
+	perFrame := false
 
 	for i, img := range anim.Image {
-		if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
-			return ErrEncode
-		}
-
 		dst := image.NewNRGBA(image.Rect(0, 0, width, height))
-		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
-		copy(frames[i*frameSize:(i+1)*frameSize], dst.Pix)
+		dst.Pix = frames[i*frameSize : (i+1)*frameSize : (i+1)*frameSize]
+		images[i] = dst
+
+		if placed {
+			r := anim.frameRect(i)
+			if !r.In(dst.Rect) {
+				return fmt.Errorf("%w: frame %d at %v outside the %dx%d canvas", ErrEncode, i, r, width, height)
+			}
+
+			// A frame smaller than the canvas is blended over the previous one, which is never
+			// disposed; a frame covering the whole canvas replaces it.
+			op := draw.Src
+			if i > 0 && r != dst.Rect {
+				copy(dst.Pix, images[i-1].Pix)
+				op = draw.Over
+			}
+
+			draw.Draw(dst, r, img, img.Bounds().Min, op)
+		} else {
+			if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+				return ErrEncode
+			}
+
+			draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+		}
 
 		if i < len(anim.Delay) {
 			delays[i] = anim.Delay[i]
 		}
+
+		configs[i] = config
+		if i < len(anim.Options) && anim.Options[i] != nil {
+			configs[i] = anim.Options[i].apply(config)
+			perFrame = perFrame || configs[i] != config
+		}
+	}
+
+	// The orientation applies to the composited canvas, so frame offsets turn with it.
+	if orientation > 1 {
+		for _, img := range images {
+			dst := applyOrientation(img, orientation).(*image.NRGBA)
+			copy(img.Pix, dst.Pix)
+			img.Rect, img.Stride = dst.Rect, dst.Stride
+		}
+
+		if orientation >= 5 {
+			width, height = height, width
+		}
 	}
 
-	data, err := encodeAnimation(frames, width, height, len(anim.Image), delays, anim.LoopCount, quality, method, lossless, exact)
+	var data []byte
+	switch {
+	case backend == BackendDynamic:
+		data, err = encodeAnimationDynamic(frames, width, height, len(anim.Image), delays, params, configs, threads)
+	case perFrame || params.needsFrameEncoder():
+		data, err = encodeFrames(images, delays, params, configs)
+	default:
+		data, err = encodeAnimation(frames, width, height, len(anim.Image), delays, params.loopCount,
+			config.quality, config.method, config.lossless, config.exact)
+		if err == nil && params.bgcolor != 0xffffffff {
+			err = setAnimParams(data, params.bgcolor, params.loopCount)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
+	if exif != nil {
+		return SetMetadata(w, bytes.NewReader(data), Metadata{EXIF: exif})
+	}
+
 	_, err = w.Write(data)
 
 	return err
 }
 
+// observerOf returns the observer of a call made with the optional opts.
+func observerOf(opts []Options) Observer {
+	if len(opts) > 0 {
+		return opts[0].observer()
+	}
+
+	return Options{}.observer()
+}
+
 // Dynamic returns error (if there was any) during opening dynamic/shared library.
 func Dynamic() error {
 	return dynamicErr
@@ -5,7 +5,6 @@ package webp
 import (
 	"fmt"
 	"image"
-	"image/color"
 	"io"
 	"runtime"
 	"unsafe"
@@ -13,7 +12,7 @@ import (
 	"github.com/ebitengine/purego"
 )
 
-func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error) {
+func decodeDynamic(r io.Reader, configOnly, decodeAll bool, mode ColorMode, threads int) (*WEBP, image.Config, error) {
 	var cfg image.Config
 
 	var err error
@@ -50,10 +49,7 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config
 	cfg.Width = int(config.Input.Width)
 	cfg.Height = int(config.Input.Height)
 
-	cfg.ColorModel = color.NYCbCrAModel
-	if hasAnimation {
-		cfg.ColorModel = color.RGBAModel
-	}
+	cfg.ColorModel = mode.colorModel(hasAnimation)
 
 	if configOnly {
 		return nil, cfg, nil
@@ -68,7 +64,10 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config
 		var options webpAnimDecoderOptions
 		webpAnimDecoderOptionsInit(&options)
 		options.ColorMode = modeRgbA
-		options.UseThreads = 1
+		if mode == ModeNRGBA {
+			options.ColorMode = modeRGBA
+		}
+		options.UseThreads = useThreads(threads)
 
 		decoder := webpAnimDecoderNew(&wpData, &options)
 		defer webpAnimDecoderDelete(decoder)
@@ -81,10 +80,15 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config
 				return nil, cfg, ErrDecode
 			}
 
-			img := image.NewRGBA(rect)
-			copy(img.Pix, unsafe.Slice(out, cfg.Width*cfg.Height*4))
-
-			images = append(images, img)
+			if options.ColorMode == modeRGBA {
+				img := image.NewNRGBA(rect)
+				copy(img.Pix, unsafe.Slice(out, cfg.Width*cfg.Height*4))
+				images = append(images, mode.fromNRGBA(img))
+			} else {
+				img := image.NewRGBA(rect)
+				copy(img.Pix, unsafe.Slice(out, cfg.Width*cfg.Height*4))
+				images = append(images, mode.fromRGBA(img))
+			}
 			delay = append(delay, timestamp-timestampPrev)
 
 			timestampPrev = timestamp
@@ -99,13 +103,55 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config
 			Delay: delay,
 		}
 
+		if hasAnimation {
+			animInfo(ret, data)
+		}
+
 		runtime.KeepAlive(data)
 
 		return ret, cfg, nil
 	}
 
+	config.Options.UseThreads = useThreads(threads)
+
+	if !mode.yuva() {
+		config.Output.Colorspace = modeRgbA
+		if mode == ModeNRGBA {
+			config.Output.Colorspace = modeRGBA
+		}
+
+		if !webpDecode(wpData.Bytes, wpData.Size, &config) {
+			return nil, cfg, ErrDecode
+		}
+
+		out := *(*webpRGBABuffer)(unsafe.Pointer(&config.Output.U))
+		pix := unsafe.Slice(out.RGBA, out.Size)
+
+		if config.Output.Colorspace == modeRGBA {
+			img := image.NewNRGBA(rect)
+			for y := 0; y < cfg.Height; y++ {
+				copy(img.Pix[y*img.Stride:(y+1)*img.Stride], pix[y*int(out.Stride):])
+			}
+			images = append(images, img)
+		} else {
+			img := image.NewRGBA(rect)
+			for y := 0; y < cfg.Height; y++ {
+				copy(img.Pix[y*img.Stride:(y+1)*img.Stride], pix[y*int(out.Stride):])
+			}
+			images = append(images, mode.fromRGBA(img))
+		}
+
+		runtime.KeepAlive(data)
+
+		ret := &WEBP{
+			Image: images,
+			Delay: delay,
+		}
+
+		return ret, cfg, nil
+	}
+
 	config.Output.Colorspace = modeYUVA
-	config.Options.UseThreads = 1
 
 	if !webpDecode(wpData.Bytes, wpData.Size, &config) {
 		return nil, cfg, ErrDecode
@@ -131,14 +177,14 @@ func decodeDynamic(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config
 	return ret, cfg, nil
 }
 
-func encodeDynamic(w io.Writer, m image.Image, quality, method int, lossless, exact bool) error {
+func encodeDynamic(w io.Writer, m image.Image, quality, method int, lossless, exact bool, threads int) error {
 	var config webpConfig
 	if !webpConfigInit(&config) {
 		return ErrEncode
 	}
 
 	config.Quality = float32(quality)
-	config.ThreadLevel = 1
+	config.ThreadLevel = useThreads(threads)
 	config.Method = int32(method)
 
 	config.Lossless = 0
@@ -216,6 +262,123 @@ func encodeDynamic(w io.Writer, m image.Image, quality, method int, lossless, ex
 	return nil
 }
 
+// encodeAnimationDynamic encodes the frames (concatenated RGBA, frameSize each) into an animated WEBP with libwebpmux.
+func encodeAnimationDynamic(frames []byte, width, height, count int, delays []int, anim animOptions, configs []frameConfig, threads int) ([]byte, error) {
+	if !dynamicMux {
+		return nil, dynamicMuxErr
+	}
+
+	var options webpAnimEncoderOptions
+	if !webpAnimEncoderOptionsInit(&options) {
+		return nil, ErrEncode
+	}
+	options.AnimParams.LoopCount = int32(anim.loopCount)
+	options.AnimParams.BgColor = anim.bgcolor
+	if anim.kmax > 0 {
+		options.Kmin = int32(anim.kmin)
+		options.Kmax = int32(anim.kmax)
+	}
+	if anim.minimizeSize {
+		options.MinimizeSize = 1
+	}
+	if anim.allowMixed {
+		options.AllowMixed = 1
+	}
+
+	enc := webpAnimEncoderNew(width, height, &options)
+	if enc == nil {
+		return nil, ErrEncode
+	}
+	defer webpAnimEncoderDelete(enc)
+
+	var config webpConfig
+	if !webpConfigInit(&config) {
+		return nil, ErrEncode
+	}
+
+	config.ThreadLevel = useThreads(threads)
+
+	frameSize := width * height * 4
+	timestamp := 0
+
+	for i := 0; i < count; i++ {
+		fc := configs[i]
+
+		config.Quality = float32(fc.quality)
+		config.Method = int32(fc.method)
+
+		config.Lossless = 0
+		if fc.lossless {
+			config.Lossless = 1
+		}
+
+		config.Exact = 0
+		if fc.exact {
+			config.Exact = 1
+		}
+
+		var picture webpPicture
+		if !webpPictureInit(&picture) {
+			return nil, ErrEncode
+		}
+
+		picture.UseArgb = 1
+		picture.Width = int32(width)
+		picture.Height = int32(height)
+
+		if !webpPictureImportRGBA(&picture, &frames[i*frameSize], width*4) {
+			webpPictureFree(&picture)
+			return nil, ErrEncode
+		}
+
+		ok := webpAnimEncoderAdd(enc, &picture, timestamp, &config)
+		webpPictureFree(&picture)
+		if !ok {
+			return nil, ErrEncode
+		}
+
+		timestamp += delays[i]
+	}
+
+	if !webpAnimEncoderAdd(enc, nil, timestamp, nil) {
+		return nil, ErrEncode
+	}
+
+	var out webpData
+	if !webpAnimEncoderAssemble(enc, &out) {
+		return nil, ErrEncode
+	}
+	defer webpFree(out.Bytes)
+
+	data := make([]byte, out.Size)
+	copy(data, unsafe.Slice(out.Bytes, out.Size))
+
+	runtime.KeepAlive(frames)
+
+	return data, nil
+}
+
+// useThreads maps Options.Threads to libwebp's use_threads/thread_level flag. 0 keeps the flag
+// this backend always set before Threads existed, which is on; 1 turns it off, and larger
+// values turn it on (libwebp picks its own thread count).
+func useThreads(threads int) int32 {
+	switch {
+	case threads == 0:
+		return defaultUseThreads
+	case threads == 1:
+		return 0
+	}
+
+	return 1
+}
+
+// defaultUseThreads is the use_threads/thread_level flag of calls that leave Threads 0.
+const defaultUseThreads = 1
+
+func versionDynamic() (decoder, encoder int) {
+	return int(_webpGetDecoderVersion()), int(_webpGetEncoderVersion())
+}
+
 func write(d *uint8, size uint64, picture *webpPicture) int {
 	w := *(*io.Writer)(unsafe.Pointer(picture.CustomPtr))
 
@@ -234,6 +397,11 @@ func init() {
 			dynamic = false
 			dynamicErr = fmt.Errorf("%v", r)
 		}
+
+		if !dynamic {
+			dynamicMux = false
+			dynamicMuxErr = dynamicErr
+		}
 	}()
 
 	libwebp, err = loadLibrary(libname)
@@ -252,6 +420,13 @@ func init() {
 		return
 	}
 
+	libwebpMux, err = loadLibrary(libnameMux)
+	if err == nil {
+		dynamicMux = true
+	} else {
+		dynamicMuxErr = err
+	}
+
 	purego.RegisterLibFunc(&_webpAnimDecoderOptionsInit, libwebpDemux, "WebPAnimDecoderOptionsInitInternal")
 	purego.RegisterLibFunc(&_webpAnimDecoderNew, libwebpDemux, "WebPAnimDecoderNewInternal")
 	purego.RegisterLibFunc(&_webpAnimDecoderGetNext, libwebpDemux, "WebPAnimDecoderGetNext")
@@ -266,14 +441,31 @@ func init() {
 	purego.RegisterLibFunc(&_webpPictureFree, libwebp, "WebPPictureFree")
 	purego.RegisterLibFunc(&_webpFreeDecBuffer, libwebp, "WebPFreeDecBuffer")
 	purego.RegisterLibFunc(&_webpEncode, libwebp, "WebPEncode")
+	purego.RegisterLibFunc(&_webpGetDecoderVersion, libwebp, "WebPGetDecoderVersion")
+	purego.RegisterLibFunc(&_webpGetEncoderVersion, libwebp, "WebPGetEncoderVersion")
+	purego.RegisterLibFunc(&_webpFree, libwebp, "WebPFree")
+
+	if !dynamicMux {
+		return
+	}
+
+	purego.RegisterLibFunc(&_webpAnimEncoderOptionsInit, libwebpMux, "WebPAnimEncoderOptionsInitInternal")
+	purego.RegisterLibFunc(&_webpAnimEncoderNew, libwebpMux, "WebPAnimEncoderNewInternal")
+	purego.RegisterLibFunc(&_webpAnimEncoderAdd, libwebpMux, "WebPAnimEncoderAdd")
+	purego.RegisterLibFunc(&_webpAnimEncoderAssemble, libwebpMux, "WebPAnimEncoderAssemble")
+	purego.RegisterLibFunc(&_webpAnimEncoderDelete, libwebpMux, "WebPAnimEncoderDelete")
 }
 
 var (
 	libwebp      uintptr
 	libwebpDemux uintptr
+	libwebpMux   uintptr
 	dynamic      bool
 	dynamicErr   error
 
+	dynamicMux    bool
+	dynamicMuxErr error
+
 	writeCallback = purego.NewCallback(write)
 )
 
@@ -292,6 +484,14 @@ var (
 	_webpPictureFree              func(*webpPicture)
 	_webpFreeDecBuffer            func(*webpDecBuffer)
 	_webpEncode                   func(*webpConfig, *webpPicture) int
+	_webpGetDecoderVersion        func() int32
+	_webpGetEncoderVersion        func() int32
+	_webpFree                     func(*uint8)
+	_webpAnimEncoderOptionsInit   func(*webpAnimEncoderOptions, int) int
+	_webpAnimEncoderNew           func(int32, int32, *webpAnimEncoderOptions, int) *webpAnimEncoder
+	_webpAnimEncoderAdd           func(*webpAnimEncoder, *webpPicture, int32, *webpConfig) int
+	_webpAnimEncoderAssemble      func(*webpAnimEncoder, *webpData) int
+	_webpAnimEncoderDelete        func(*webpAnimEncoder)
 )
 
 func webpAnimDecoderOptionsInit(options *webpAnimDecoderOptions) {
@@ -318,6 +518,36 @@ func webpAnimDecoderDelete(decoder *webpAnimDecoder) {
 	_webpAnimDecoderDelete(decoder)
 }
 
+func webpAnimEncoderOptionsInit(options *webpAnimEncoderOptions) bool {
+	ret := _webpAnimEncoderOptionsInit(options, webpMuxABIVersion)
+
+	return ret != 0
+}
+
+func webpAnimEncoderNew(width, height int, options *webpAnimEncoderOptions) *webpAnimEncoder {
+	return _webpAnimEncoderNew(int32(width), int32(height), options, webpMuxABIVersion)
+}
+
+func webpAnimEncoderAdd(encoder *webpAnimEncoder, picture *webpPicture, timestamp int, config *webpConfig) bool {
+	ret := _webpAnimEncoderAdd(encoder, picture, int32(timestamp), config)
+
+	return ret != 0
+}
+
+func webpAnimEncoderAssemble(encoder *webpAnimEncoder, data *webpData) bool {
+	ret := _webpAnimEncoderAssemble(encoder, data)
+
+	return ret != 0
+}
+
+func webpAnimEncoderDelete(encoder *webpAnimEncoder) {
+	_webpAnimEncoderDelete(encoder)
+}
+
+func webpFree(p *uint8) {
+	_webpFree(p)
+}
+
 func webpDecode(data *uint8, size uint64, config *webpDecoderConfig) bool {
 	ret := _webpDecode(data, size, config)
 
@@ -369,12 +599,15 @@ func webpEncode(config *webpConfig, picture *webpPicture) bool {
 }
 
 const (
+	modeRGBA = 1
 	modeRgbA = 7
 	modeYUVA = 12
 )
 
 type webpAnimDecoder struct{}
 
+type webpAnimEncoder struct{}
+
 type webpData struct {
 	Bytes *uint8
 	Size  uint64
@@ -424,6 +657,12 @@ type webpBitstreamFeatures struct {
 	_         [5]uint32
 }
 
+type webpRGBABuffer struct {
+	RGBA   *uint8
+	Stride int32
+	Size   uint64
+}
+
 type webpYUVABuffer struct {
 	Y       *uint8
 	U       *uint8
@@ -526,6 +765,21 @@ type webpAuxStats struct {
 	Pad              [2]uint32
 }
 
+type webpMuxAnimParams struct {
+	BgColor   uint32
+	LoopCount int32
+}
+
+type webpAnimEncoderOptions struct {
+	AnimParams   webpMuxAnimParams
+	MinimizeSize int32
+	Kmin         int32
+	Kmax         int32
+	AllowMixed   int32
+	Verbose      int32
+	Padding      [4]uint32
+}
+
 type webpAnimDecoderOptions struct {
 	ColorMode  uint32
 	UseThreads int32
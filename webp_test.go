@@ -3,12 +3,15 @@ package webp
 import (
 	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"math"
 	"os"
 	"sync"
 	"testing"
@@ -41,7 +44,7 @@ func TestDecode(t *testing.T) {
 }
 
 func TestDecodeWasm2go(t *testing.T) {
-	img, _, err := decode(bytes.NewReader(testWebp), false, false)
+	img, _, err := decode(bytes.NewReader(testWebp), false, false, ModeDefault, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -63,7 +66,7 @@ func TestDecodeDynamic(t *testing.T) {
 		t.Skip()
 	}
 
-	img, _, err := decodeDynamic(bytes.NewReader(testWebp), false, false)
+	img, _, err := decodeDynamic(bytes.NewReader(testWebp), false, false, ModeDefault, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -80,7 +83,7 @@ func TestDecodeDynamic(t *testing.T) {
 }
 
 func TestDecodeAnimWasm2go(t *testing.T) {
-	ret, _, err := decode(bytes.NewReader(testWebpAnim), false, true)
+	ret, _, err := decode(bytes.NewReader(testWebpAnim), false, true, ModeDefault, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -117,7 +120,7 @@ func TestDecodeAnimDynamic(t *testing.T) {
 		t.Skip()
 	}
 
-	ret, _, err := decodeDynamic(bytes.NewReader(testWebpAnim), false, true)
+	ret, _, err := decodeDynamic(bytes.NewReader(testWebpAnim), false, true, ModeDefault, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -278,7 +281,7 @@ func TestEncodeWasm2go(t *testing.T) {
 // laid out contiguously and with padded, independently-allocated planes must
 // encode identically.
 func TestEncodeNYCbCrAStrided(t *testing.T) {
-	ret, _, err := decode(bytes.NewReader(testWebp), false, false)
+	ret, _, err := decode(bytes.NewReader(testWebp), false, false, ModeDefault, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -353,6 +356,87 @@ func TestEncodeWasm2goSync(t *testing.T) {
 	wg.Wait()
 }
 
+func TestWasm2goTrap(t *testing.T) {
+	err := func() (err error) {
+		mod := newModule()
+		defer mod.guard("free", &err)
+
+		mod.Xfree(-16)
+
+		return nil
+	}()
+
+	var te *TrapError
+	if !errors.As(err, &te) || !errors.Is(err, ErrTrap) || te.Op != "free" {
+		t.Fatalf("got %v, want a trap in free", err)
+	}
+
+	if _, err := newModule().malloc(math.MaxInt32); !errors.Is(err, ErrMemAlloc) {
+		t.Errorf("got %v, want %v", err, ErrMemAlloc)
+	}
+
+	// Corrupt and truncated inputs may decode partially or fail, but must not panic.
+	for _, n := range []int{12, 20, 30, 40, 64, len(testWebp) / 2, len(testWebp) - 1} {
+		data := bytes.Clone(testWebp[:n])
+		for i := 30; i < len(data); i += 7 {
+			data[i] ^= 0x5a
+		}
+
+		_, _, _ = decode(bytes.NewReader(data), false, true, ModeDefault, 0)
+	}
+}
+
+func TestWasm2goDiagnostics(t *testing.T) {
+	printf := func(mod *module, fd int32, msg string) {
+		ptr, err := mod.malloc(12 + len(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store32(mod.memory[ptr:], uint32(ptr+12))
+		store32(mod.memory[ptr+4:], uint32(len(msg)))
+		copy(mod.memory[ptr+12:], msg)
+
+		mod.fn1(fd, ptr, 1, ptr+8)
+	}
+
+	err := func() (err error) {
+		mod := newModule()
+		defer mod.guard("encode", &err)
+
+		printf(mod, 2, "ERROR adding frame\n")
+		printf(mod, 3, "ignored\n")
+		printf(mod, 1, "ERROR assembling WebP\n")
+
+		return ErrEncode
+	}()
+
+	var de *DiagnosticError
+	if !errors.As(err, &de) || !errors.Is(err, ErrEncode) {
+		t.Fatalf("got %v, want a diagnostic error", err)
+	}
+
+	if want := "ERROR adding frame\nERROR assembling WebP"; de.Output != want {
+		t.Errorf("got output %q, want %q", de.Output, want)
+	}
+
+	if want := "webp: encode failed: libwebp: ERROR adding frame; ERROR assembling WebP"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+
+	err = func() (err error) {
+		mod := newModule()
+		defer mod.guard("encode", &err)
+
+		printf(mod, 2, "WARNING: only a warning\n")
+
+		return nil
+	}()
+	if err != nil {
+		t.Errorf("got %v for a successful call", err)
+	}
+}
+
 func TestEncodeDynamic(t *testing.T) {
 	if err := Dynamic(); err != nil {
 		fmt.Println(err)
@@ -369,7 +453,7 @@ func TestEncodeDynamic(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = encodeDynamic(w, img, DefaultQuality, DefaultMethod, false, false)
+	err = encodeDynamic(w, img, DefaultQuality, DefaultMethod, false, false, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -377,7 +461,7 @@ func TestEncodeDynamic(t *testing.T) {
 
 func BenchmarkDecodeWasm2go(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		_, _, err := decode(bytes.NewReader(testWebp), false, false)
+		_, _, err := decode(bytes.NewReader(testWebp), false, false, ModeDefault, 0)
 		if err != nil {
 			b.Error(err)
 		}
@@ -391,7 +475,7 @@ func BenchmarkDecodeDynamic(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		_, _, err := decodeDynamic(bytes.NewReader(testWebp), false, false)
+		_, _, err := decodeDynamic(bytes.NewReader(testWebp), false, false, ModeDefault, 0)
 		if err != nil {
 			b.Error(err)
 		}
@@ -424,7 +508,7 @@ func BenchmarkEncodeDynamic(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		err = encodeDynamic(io.Discard, img, DefaultQuality, DefaultMethod, false, false)
+		err = encodeDynamic(io.Discard, img, DefaultQuality, DefaultMethod, false, false, 0)
 		if err != nil {
 			b.Error(err)
 		}
@@ -491,3 +575,322 @@ func TestEncodeAll(t *testing.T) {
 		t.Errorf("frame size = %v, want 64x48", dec.Image[0].Bounds().Max)
 	}
 }
+
+func gradientFrame(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) % 256), 255})
+		}
+	}
+	return img
+}
+
+func TestDecodeColorMode(t *testing.T) {
+	src := gradientFrame(37, 21)
+
+	var buf bytes.Buffer
+	if err := encode(&buf, src, 100, DefaultMethod, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode  ColorMode
+		model color.Model
+	}{
+		{ModeDefault, color.NYCbCrAModel},
+		{ModeRGBA, color.RGBAModel},
+		{ModeNRGBA, color.NRGBAModel},
+		{ModeYCbCrA, color.NYCbCrAModel},
+		{ModeGray, color.GrayModel},
+	}
+
+	for _, tt := range tests {
+		ret, cfg, err := decode(bytes.NewReader(buf.Bytes()), false, false, tt.mode, 0)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.mode, err)
+		}
+
+		if cfg.ColorModel != tt.model {
+			t.Errorf("%v: color model mismatch", tt.mode)
+		}
+
+		img := ret.Image[0]
+		if img.ColorModel() != tt.model {
+			t.Errorf("%v: got %T", tt.mode, img)
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Errorf("%v: bounds %v, want %v", tt.mode, img.Bounds(), src.Bounds())
+		}
+	}
+
+	// Lossless input must come back pixel-exact in the RGB modes.
+	for _, mode := range []ColorMode{ModeRGBA, ModeNRGBA} {
+		img, err := Decode(bytes.NewReader(buf.Bytes()), Options{ColorMode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for y := 0; y < src.Rect.Dy(); y++ {
+			for x := 0; x < src.Rect.Dx(); x++ {
+				if got := color.NRGBAModel.Convert(img.At(x, y)); got != src.NRGBAAt(x, y) {
+					t.Fatalf("%v: pixel (%d,%d) = %v, want %v", mode, x, y, got, src.NRGBAAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeColorModeTranslucent(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := uint8(i * 4)
+		copy(src.Pix[i:], []uint8{200, 100, 50, a})
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, src, 100, DefaultMethod, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The wasm backend unpremultiplies, so a channel may be off by up to 255/a, rounded up.
+	ret, _, err := decode(bytes.NewReader(buf.Bytes()), false, false, ModeNRGBA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := ret.Image[0].(*image.NRGBA)
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := int(src.Pix[i+3])
+		if int(img.Pix[i+3]) != a {
+			t.Fatalf("pixel %d: alpha %d, want %d", i/4, img.Pix[i+3], a)
+		}
+
+		for c := 0; c < 3 && a > 0; c++ {
+			if d, tol := int(img.Pix[i+c])-int(src.Pix[i+c]), (255+a-1)/a; d > tol || -d > tol {
+				t.Errorf("pixel %d: got %v, want %v", i/4, img.Pix[i:i+4], src.Pix[i:i+4])
+			}
+		}
+	}
+
+	if err := Dynamic(); err != nil {
+		return
+	}
+
+	// The dynamic backend decodes to straight RGBA and must be exact.
+	ret, _, err = decodeDynamic(bytes.NewReader(buf.Bytes()), false, false, ModeNRGBA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ret.Image[0].(*image.NRGBA).Pix; !bytes.Equal(got, src.Pix) {
+		t.Errorf("dynamic: got %v, want %v", got[:16], src.Pix[:16])
+	}
+
+	// Gray is the luma of the premultiplied color on both backends.
+	wasm, _, err := decode(bytes.NewReader(buf.Bytes()), false, false, ModeGray, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	native, _, err := decodeDynamic(bytes.NewReader(buf.Bytes()), false, false, ModeGray, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(wasm.Image[0].(*image.Gray).Pix, native.Image[0].(*image.Gray).Pix) {
+		t.Error("gray differs between the backends")
+	}
+}
+
+func TestDecodeColorModeAnim(t *testing.T) {
+	for _, mode := range []ColorMode{ModeRGBA, ModeNRGBA, ModeYCbCrA, ModeGray} {
+		ret, _, err := decode(bytes.NewReader(testWebpAnim), false, true, mode, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ret.Image) != 17 {
+			t.Errorf("%v: got %d frames, want %d", mode, len(ret.Image), 17)
+		}
+
+		if ret.Image[0].ColorModel() != mode.colorModel(true) {
+			t.Errorf("%v: got %T", mode, ret.Image[0])
+		}
+	}
+}
+
+func TestDecodeColorModeDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	for _, data := range [][]byte{testWebp, testWebpAnim} {
+		for _, mode := range []ColorMode{ModeRGBA, ModeNRGBA, ModeGray} {
+			want, _, err := decode(bytes.NewReader(data), false, false, mode, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _, err := decodeDynamic(bytes.NewReader(data), false, false, mode, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Image[0].ColorModel() != want.Image[0].ColorModel() {
+				t.Errorf("%v: got %T, want %T", mode, got.Image[0], want.Image[0])
+			}
+			if got.Image[0].Bounds() != want.Image[0].Bounds() {
+				t.Errorf("%v: bounds %v, want %v", mode, got.Image[0].Bounds(), want.Image[0].Bounds())
+			}
+		}
+	}
+}
+
+func TestEncodeAllDynamic(t *testing.T) {
+	if err := Dynamic(); err != nil {
+		fmt.Println(err)
+		t.Skip()
+	}
+
+	anim := &WEBP{
+		Image: []image.Image{
+			gradientFrame(64, 48),
+			solidFrame(64, 48, color.NRGBA{0, 255, 0, 255}),
+			solidFrame(64, 48, color.NRGBA{0, 0, 255, 128}),
+		},
+		Delay:     []int{100, 200, 150},
+		LoopCount: 2,
+	}
+
+	for _, lossless := range []bool{true, false} {
+		var native, wasm bytes.Buffer
+		if err := EncodeAll(&native, anim, Options{Lossless: lossless, Backend: BackendDynamic}); err != nil {
+			t.Fatal(err)
+		}
+		if err := EncodeAll(&wasm, anim, Options{Lossless: lossless, Backend: BackendWasm}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodeAll(bytes.NewReader(native.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DecodeAll(bytes.NewReader(wasm.Bytes()), Options{ColorMode: ModeNRGBA, Backend: BackendWasm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Image) != len(want.Image) {
+			t.Fatalf("lossless=%v: got %d frames, want %d", lossless, len(got.Image), len(want.Image))
+		}
+
+		for i := range want.Image {
+			if got.Delay[i] != want.Delay[i] {
+				t.Errorf("lossless=%v: frame %d delay %d, want %d", lossless, i, got.Delay[i], want.Delay[i])
+			}
+
+			if d := maxDiff(got.Image[i].(*image.NRGBA), want.Image[i].(*image.NRGBA)); (lossless && d != 0) || d > 16 {
+				t.Errorf("lossless=%v: frame %d differs by %d", lossless, i, d)
+			}
+		}
+	}
+}
+
+func maxDiff(a, b *image.NRGBA) int {
+	d := 0
+	for i := range a.Pix {
+		v := int(a.Pix[i]) - int(b.Pix[i])
+		if v < 0 {
+			v = -v
+		}
+		if v > d {
+			d = v
+		}
+	}
+	return d
+}
+
+func TestDecodeAnimThreads(t *testing.T) {
+	anim := &WEBP{
+		Image: []image.Image{
+			gradientFrame(64, 48),
+			solidFrame(64, 48, color.NRGBA{0, 255, 0, 96}),
+			gradientFrame(64, 48),
+			solidFrame(64, 48, color.NRGBA{0, 0, 255, 255}),
+		},
+		Delay: []int{100, 200, 150, 50},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, anim, Options{Backend: BackendWasm}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{testWebpAnim, buf.Bytes()} {
+		want, _, err := decode(bytes.NewReader(data), false, true, ModeRGBA, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _, err := decode(bytes.NewReader(data), false, true, ModeRGBA, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got.Image) != len(want.Image) {
+			t.Fatalf("got %d frames, want %d", len(got.Image), len(want.Image))
+		}
+
+		for i := range want.Image {
+			if got.Delay[i] != want.Delay[i] {
+				t.Errorf("frame %d: delay %d, want %d", i, got.Delay[i], want.Delay[i])
+			}
+
+			if !bytes.Equal(got.Image[i].(*image.RGBA).Pix, want.Image[i].(*image.RGBA).Pix) {
+				t.Errorf("frame %d: concurrent decode differs from sequential", i)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeAnimWasm2go(b *testing.B) {
+	for _, threads := range []int{1, 4} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := decode(bytes.NewReader(testWebpAnim), false, true, ModeDefault, threads)
+				if err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeAllWasm2go(b *testing.B) {
+	anim := &WEBP{Delay: []int{100, 100, 100, 100}}
+	for i := 0; i < 4; i++ {
+		img := gradientFrame(128, 96)
+		draw.Draw(img, image.Rect(i*16, i*16, i*16+32, i*16+32), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, img)
+	}
+
+	for _, bb := range []struct {
+		name string
+		opt  Options
+	}{
+		{"libwebp", Options{}},
+		{"kmax", Options{Kmin: 2, Kmax: 3}},
+		{"mixed", Options{AllowMixed: true}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			bb.opt.Backend = BackendWasm
+			for i := 0; i < b.N; i++ {
+				if err := EncodeAll(io.Discard, anim, bb.opt); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -4,16 +4,72 @@ import (
 	"encoding/binary"
 	"fmt"
 	"image"
-	"image/color"
 	"io"
+	"math"
+	"strings"
 )
 
-func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error) {
+// maxDiagnostics bounds the libwebp output kept per call.
+const maxDiagnostics = 4096
+
+// TrapError reports a trap in the transpiled libwebp, such as an out of bounds memory access
+// or an abort, recovered so that a malformed input fails the call instead of the process.
+// It wraps ErrTrap.
+type TrapError struct {
+	// Op is the operation that trapped.
+	Op string
+	// Value is the recovered panic value.
+	Value any
+}
+
+func (e *TrapError) Error() string {
+	return fmt.Sprintf("webp: wasm trap in %s: %v", e.Op, e.Value)
+}
+
+func (e *TrapError) Unwrap() error {
+	return ErrTrap
+}
+
+// DiagnosticError is an error of the wasm backend together with the messages libwebp wrote
+// to stdout/stderr during the call, which often explain the failure.
+type DiagnosticError struct {
+	// Err is the underlying error.
+	Err error
+	// Output is the libwebp output, one message per line.
+	Output string
+}
+
+func (e *DiagnosticError) Error() string {
+	return fmt.Sprintf("%v: libwebp: %s", e.Err, strings.ReplaceAll(e.Output, "\n", "; "))
+}
+
+func (e *DiagnosticError) Unwrap() error {
+	return e.Err
+}
+
+// guard converts a panic of the module into a *TrapError stored in err and wraps a failure
+// in a *DiagnosticError when libwebp printed anything; it must be deferred directly. The
+// module is discarded afterwards, its state is not trusted again.
+func (m *module) guard(op string, err *error) {
+	if r := recover(); r != nil {
+		*err = &TrapError{Op: op, Value: r}
+	}
+
+	if *err == nil {
+		return
+	}
+
+	if out := m.diagnostics(); out != "" {
+		*err = &DiagnosticError{Err: *err, Output: out}
+	}
+}
+
+func decode(r io.Reader, configOnly, decodeAll bool, mode ColorMode, threads int) (_ *WEBP, _ image.Config, err error) {
 	var cfg image.Config
 	var data []byte
-	var err error
 
 	mod := newModule()
+	defer mod.guard("decode", &err)
 
 	if configOnly {
 		data, err = io.ReadAll(io.LimitReader(r, webpMaxHeaderSize))
@@ -29,7 +85,10 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 
 	inSize := len(data)
 
-	inPtr := mod.Xmalloc(int32(inSize))
+	inPtr, err := mod.malloc(inSize)
+	if err != nil {
+		return nil, cfg, err
+	}
 	defer mod.Xfree(inPtr)
 
 	ok := mod.write(inPtr, data)
@@ -37,7 +96,10 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 		return nil, cfg, ErrMemWrite
 	}
 
-	ptr := mod.Xmalloc(4 * 4)
+	ptr, err := mod.malloc(4 * 4)
+	if err != nil {
+		return nil, cfg, err
+	}
 	defer mod.Xfree(ptr)
 
 	widthPtr := ptr
@@ -75,30 +137,54 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 	cfg.Width = int(width)
 	cfg.Height = int(height)
 
-	cfg.ColorModel = color.NYCbCrAModel
-	if hasAnimation {
-		cfg.ColorModel = color.RGBAModel
-	}
+	cfg.ColorModel = mode.colorModel(hasAnimation)
 
 	if configOnly {
 		return nil, cfg, nil
 	}
 
+	// Frames of an animation can be decoded independently and composited in Go.
+	if decodeAll && hasAnimation && threads > 1 {
+		if c, err := parseContainer(data); err == nil {
+			ret, err := decodeFrames(c, threads, mode)
+			if err != nil {
+				return nil, cfg, err
+			}
+
+			return ret, cfg, nil
+		}
+	}
+
 	delay := make([]int, 0)
 	images := make([]image.Image, 0)
 
-	if decodeAll || hasAnimation {
+	// RGB modes of a still image go through the animation decoder, which yields
+	// premultiplied RGBA straight from the bitstream (no YUV round trip for lossless).
+	if !hasAnimation && !mode.yuva() {
+		all = 1
+	}
+
+	if decodeAll || hasAnimation || !mode.yuva() {
 		count, ok := mod.readUint32(countPtr)
 		if !ok {
 			return nil, cfg, ErrMemRead
 		}
 
 		size := cfg.Width * cfg.Height * 4
+		if int64(size)*int64(count) > math.MaxInt32 {
+			return nil, cfg, fmt.Errorf("%w: %d frames of %dx%d exceed the wasm address space", ErrLimit, count, cfg.Width, cfg.Height)
+		}
 
-		outPtr := mod.Xmalloc(int32(size * int(count)))
+		outPtr, err := mod.malloc(size * int(count))
+		if err != nil {
+			return nil, cfg, err
+		}
 		defer mod.Xfree(outPtr)
 
-		delayPtr := mod.Xmalloc(int32(4 * int(count)))
+		delayPtr, err := mod.malloc(4 * int(count))
+		if err != nil {
+			return nil, cfg, err
+		}
 		defer mod.Xfree(delayPtr)
 
 		res = mod.Xdecode(inPtr, int32(inSize), 0, all, widthPtr, heightPtr, countPtr, animPtr, delayPtr, outPtr)
@@ -115,7 +201,7 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 			img := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
 			img.Pix = out
 
-			images = append(images, img)
+			images = append(images, mode.fromRGBA(img))
 
 			d, ok := mod.readUint32(delayPtr + int32(i*4))
 			if !ok {
@@ -130,6 +216,10 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 			Delay: delay,
 		}
 
+		if hasAnimation {
+			animInfo(ret, data)
+		}
+
 		return ret, cfg, nil
 	}
 
@@ -145,7 +235,10 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 
 	size := i3
 
-	outPtr := mod.Xmalloc(int32(size))
+	outPtr, err := mod.malloc(size)
+	if err != nil {
+		return nil, cfg, err
+	}
 	defer mod.Xfree(outPtr)
 
 	res = mod.Xdecode(inPtr, int32(inSize), 0, all, widthPtr, heightPtr, countPtr, animPtr, 0, outPtr)
@@ -182,8 +275,9 @@ func decode(r io.Reader, configOnly, decodeAll bool) (*WEBP, image.Config, error
 	return ret, cfg, nil
 }
 
-func encode(w io.Writer, m image.Image, quality, method int, lossless, exact bool) error {
+func encode(w io.Writer, m image.Image, quality, method int, lossless, exact bool) (err error) {
 	mod := newModule()
+	defer mod.guard("encode", &err)
 
 	var data []byte
 	var colorspace int
@@ -238,7 +332,10 @@ func encode(w io.Writer, m image.Image, quality, method int, lossless, exact boo
 		data = i.Pix
 	}
 
-	inPtr := mod.Xmalloc(int32(len(data)))
+	inPtr, err := mod.malloc(len(data))
+	if err != nil {
+		return err
+	}
 	defer mod.Xfree(inPtr)
 
 	ok := mod.write(inPtr, data)
@@ -246,7 +343,10 @@ func encode(w io.Writer, m image.Image, quality, method int, lossless, exact boo
 		return ErrMemWrite
 	}
 
-	sizePtr := mod.Xmalloc(8)
+	sizePtr, err := mod.malloc(8)
+	if err != nil {
+		return err
+	}
 	defer mod.Xfree(sizePtr)
 
 	losslessVal := int32(0)
@@ -268,16 +368,20 @@ func encode(w io.Writer, m image.Image, quality, method int, lossless, exact boo
 		return ErrMemRead
 	}
 
-	if size == 0 {
+	if outPtr == 0 || size == 0 {
 		return ErrEncode
 	}
 
+	if size > math.MaxInt32 {
+		return ErrMemRead
+	}
+
 	out, ok := mod.read(outPtr, int32(size))
 	if !ok {
 		return ErrMemRead
 	}
 
-	_, err := w.Write(out)
+	_, err = w.Write(out)
 	if err != nil {
 		return fmt.Errorf("write: %w", err)
 	}
@@ -285,6 +389,21 @@ func encode(w io.Writer, m image.Image, quality, method int, lossless, exact boo
 	return nil
 }
 
+// malloc allocates size bytes of module memory. A null pointer from Xmalloc is an error:
+// libwebp would otherwise read and write through it at the start of the memory.
+func (m *module) malloc(size int) (int32, error) {
+	if size < 0 || size > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMemAlloc, size)
+	}
+
+	ptr := m.Xmalloc(int32(size))
+	if ptr == 0 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMemAlloc, size)
+	}
+
+	return ptr, nil
+}
+
 func (m *module) write(ptr int32, data []byte) bool {
 	if ptr < 0 || int(ptr)+len(data) > len(m.memory) {
 		return false
@@ -319,6 +438,16 @@ func (m *module) readUint64(ptr int32) (uint64, bool) {
 	return load64(m.memory[ptr:]), true
 }
 
+// diagnostics returns what libwebp wrote to stdout/stderr so far.
+func (m *module) diagnostics() string {
+	h, ok := m._wasi_snapshot_preview1.(*wasiHost)
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimSpace(string(h.out))
+}
+
 func newModule() *module {
 	mod := newModuleRaw(&wasiHost{})
 	mod.X_initialize()
@@ -326,9 +455,11 @@ func newModule() *module {
 	return mod
 }
 
-// wasiHost satisfies the module's wasi imports; libwebpmux only writes diagnostics, discarded here.
+// wasiHost satisfies the module's wasi imports. libwebp only writes diagnostics, kept up to
+// maxDiagnostics bytes to explain a failed call.
 type wasiHost struct {
 	mod *module
+	out []byte
 }
 
 func (h *wasiHost) Init(m any) {
@@ -344,7 +475,17 @@ func (h *wasiHost) Xfd_write(fd, iovs, iovsLen, nwrittenPtr int32) int32 {
 
 	var written uint32
 	for i := int32(0); i < iovsLen; i++ {
-		written += load32(mem[iovs+i*8+4:])
+		ptr := load32(mem[iovs+i*8:])
+		size := load32(mem[iovs+i*8+4:])
+		written += size
+
+		if fd != 1 && fd != 2 {
+			continue
+		}
+
+		if b, ok := h.mod.read(int32(ptr), int32(size)); ok {
+			h.out = append(h.out, b[:min(len(b), maxDiagnostics-len(h.out))]...)
+		}
 	}
 
 	store32(mem[nwrittenPtr:], written)
@@ -353,10 +494,14 @@ func (h *wasiHost) Xfd_write(fd, iovs, iovsLen, nwrittenPtr int32) int32 {
 }
 
 // encodeAnimation encodes the frames (concatenated RGBA, frameSize each) into an animated WEBP.
-func encodeAnimation(frames []byte, width, height, count int, delays []int, loopCount, quality, method int, lossless, exact bool) ([]byte, error) {
+func encodeAnimation(frames []byte, width, height, count int, delays []int, loopCount, quality, method int, lossless, exact bool) (_ []byte, err error) {
 	mod := newModule()
+	defer mod.guard("encode animation", &err)
 
-	framesPtr := mod.Xmalloc(int32(len(frames)))
+	framesPtr, err := mod.malloc(len(frames))
+	if err != nil {
+		return nil, err
+	}
 	defer mod.Xfree(framesPtr)
 	if !mod.write(framesPtr, frames) {
 		return nil, ErrMemWrite
@@ -367,13 +512,19 @@ func encodeAnimation(frames []byte, width, height, count int, delays []int, loop
 		binary.LittleEndian.PutUint32(delayBuf[i*4:], uint32(delays[i]))
 	}
 
-	delaysPtr := mod.Xmalloc(int32(len(delayBuf)))
+	delaysPtr, err := mod.malloc(len(delayBuf))
+	if err != nil {
+		return nil, err
+	}
 	defer mod.Xfree(delaysPtr)
 	if !mod.write(delaysPtr, delayBuf) {
 		return nil, ErrMemWrite
 	}
 
-	sizePtr := mod.Xmalloc(8)
+	sizePtr, err := mod.malloc(8)
+	if err != nil {
+		return nil, err
+	}
 	defer mod.Xfree(sizePtr)
 
 	losslessVal := int32(0)
@@ -399,6 +550,10 @@ func encodeAnimation(frames []byte, width, height, count int, delays []int, loop
 		return nil, ErrEncode
 	}
 
+	if size > math.MaxInt32 {
+		return nil, ErrMemRead
+	}
+
 	out, ok := mod.read(outPtr, int32(size))
 	if !ok {
 		return nil, ErrMemRead
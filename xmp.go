@@ -0,0 +1,715 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNoXMP is returned by DecodeXMP when the WEBP has no XMP chunk.
+var ErrNoXMP = errors.New("webp: no xmp data")
+
+// XMP holds the common properties of an XMP packet. Properties it has no field for, and other
+// languages of the language alternatives, are kept when a parsed packet is marshaled again.
+// Empty fields are removed from the packet.
+type XMP struct {
+	// Dublin Core (dc)
+	Title       string   // dc:title, the x-default language.
+	Description string   // dc:description, the x-default language.
+	Creator     []string // dc:creator, in order.
+	Subject     []string // dc:subject keywords.
+	Rights      string   // dc:rights copyright notice, the x-default language.
+
+	// XMP basic (xmp)
+	Rating      int    // xmp:Rating, -1 = rejected, 1-5 stars, 0 = unrated (not written).
+	CreatorTool string // xmp:CreatorTool, the software that created the resource.
+	CreateDate  string // xmp:CreateDate, e.g. "2024-05-01T12:00:00Z".
+
+	// Photoshop (photoshop)
+	Credit   string // photoshop:Credit line.
+	Source   string // photoshop:Source, the original owner.
+	Headline string // photoshop:Headline.
+
+	// IPTC Core (Iptc4xmpCore)
+	Location           string      // Iptc4xmpCore:Location, the sublocation.
+	CountryCode        string      // Iptc4xmpCore:CountryCode, ISO 3166 code.
+	IntellectualGenre  string      // Iptc4xmpCore:IntellectualGenre.
+	Scene              []string    // Iptc4xmpCore:Scene codes.
+	SubjectCode        []string    // Iptc4xmpCore:SubjectCode codes.
+	CreatorContactInfo ContactInfo // Iptc4xmpCore:CreatorContactInfo.
+
+	// packet is the parsed packet, the base for Marshal.
+	packet []byte
+}
+
+// ContactInfo is the IPTC Core contact information of the creator.
+type ContactInfo struct {
+	Address    string // Iptc4xmpCore:CiAdrExtadr
+	City       string // Iptc4xmpCore:CiAdrCity
+	Region     string // Iptc4xmpCore:CiAdrRegion
+	PostalCode string // Iptc4xmpCore:CiAdrPcode
+	Country    string // Iptc4xmpCore:CiAdrCtry
+	Email      string // Iptc4xmpCore:CiEmailWork
+	Phone      string // Iptc4xmpCore:CiTelWork
+	URL        string // Iptc4xmpCore:CiUrlWork
+}
+
+// XMP namespaces
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMPBasic  = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsIptcCore  = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+)
+
+// preferredPrefix is the prefix declared for a namespace that the packet does not declare yet.
+var preferredPrefix = map[string]string{
+	nsRDF:       "rdf",
+	nsDC:        "dc",
+	nsXMPBasic:  "xmp",
+	nsPhotoshop: "photoshop",
+	nsIptcCore:  "Iptc4xmpCore",
+}
+
+// xmpEmptyPacket is the base for marshaling an XMP that was not parsed.
+const xmpEmptyPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description rdf:about=""/></rdf:RDF></x:xmpmeta>
+<?xpacket end="w"?>`
+
+// xmpValue is the RDF form of a property value.
+type xmpValue int
+
+const (
+	xmpText xmpValue = iota // simple value
+	xmpLang                 // rdf:Alt language alternative
+	xmpSeq                  // rdf:Seq ordered array
+	xmpBag                  // rdf:Bag unordered array
+)
+
+// xmpProperty maps a property to the XMP field holding it; exactly one of text and list is set.
+type xmpProperty struct {
+	ns, local string
+	value     xmpValue
+	text      func(x *XMP) *string
+	list      func(x *XMP) *[]string
+}
+
+var xmpProperties = []xmpProperty{
+	{ns: nsDC, local: "title", value: xmpLang, text: func(x *XMP) *string { return &x.Title }},
+	{ns: nsDC, local: "description", value: xmpLang, text: func(x *XMP) *string { return &x.Description }},
+	{ns: nsDC, local: "creator", value: xmpSeq, list: func(x *XMP) *[]string { return &x.Creator }},
+	{ns: nsDC, local: "subject", value: xmpBag, list: func(x *XMP) *[]string { return &x.Subject }},
+	{ns: nsDC, local: "rights", value: xmpLang, text: func(x *XMP) *string { return &x.Rights }},
+	{ns: nsXMPBasic, local: "CreatorTool", text: func(x *XMP) *string { return &x.CreatorTool }},
+	{ns: nsXMPBasic, local: "CreateDate", text: func(x *XMP) *string { return &x.CreateDate }},
+	{ns: nsPhotoshop, local: "Credit", text: func(x *XMP) *string { return &x.Credit }},
+	{ns: nsPhotoshop, local: "Source", text: func(x *XMP) *string { return &x.Source }},
+	{ns: nsPhotoshop, local: "Headline", text: func(x *XMP) *string { return &x.Headline }},
+	{ns: nsIptcCore, local: "Location", text: func(x *XMP) *string { return &x.Location }},
+	{ns: nsIptcCore, local: "CountryCode", text: func(x *XMP) *string { return &x.CountryCode }},
+	{ns: nsIptcCore, local: "IntellectualGenre", text: func(x *XMP) *string { return &x.IntellectualGenre }},
+	{ns: nsIptcCore, local: "Scene", value: xmpBag, list: func(x *XMP) *[]string { return &x.Scene }},
+	{ns: nsIptcCore, local: "SubjectCode", value: xmpBag, list: func(x *XMP) *[]string { return &x.SubjectCode }},
+}
+
+// contactFields are the fields of Iptc4xmpCore:CreatorContactInfo.
+var contactFields = []xmpProperty{
+	{ns: nsIptcCore, local: "CiAdrExtadr", text: func(x *XMP) *string { return &x.CreatorContactInfo.Address }},
+	{ns: nsIptcCore, local: "CiAdrCity", text: func(x *XMP) *string { return &x.CreatorContactInfo.City }},
+	{ns: nsIptcCore, local: "CiAdrRegion", text: func(x *XMP) *string { return &x.CreatorContactInfo.Region }},
+	{ns: nsIptcCore, local: "CiAdrPcode", text: func(x *XMP) *string { return &x.CreatorContactInfo.PostalCode }},
+	{ns: nsIptcCore, local: "CiAdrCtry", text: func(x *XMP) *string { return &x.CreatorContactInfo.Country }},
+	{ns: nsIptcCore, local: "CiEmailWork", text: func(x *XMP) *string { return &x.CreatorContactInfo.Email }},
+	{ns: nsIptcCore, local: "CiTelWork", text: func(x *XMP) *string { return &x.CreatorContactInfo.Phone }},
+	{ns: nsIptcCore, local: "CiUrlWork", text: func(x *XMP) *string { return &x.CreatorContactInfo.URL }},
+}
+
+// DecodeXMP reads the XMP metadata from a WEBP image. It returns ErrNoXMP if the image carries no XMP chunk.
+func DecodeXMP(r io.Reader) (*XMP, error) {
+	m, err := ReadMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.XMP == nil {
+		return nil, ErrNoXMP
+	}
+
+	return ParseXMP(m.XMP)
+}
+
+// ParseXMP parses an XMP packet, e.g. Metadata.XMP.
+func ParseXMP(packet []byte) (*XMP, error) {
+	doc, err := parseXMLTree(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &XMP{packet: bytes.Clone(packet)}
+
+	descs := doc.descriptions()
+	for _, p := range xmpProperties {
+		if n, v, ok := findProperty(descs, p.ns, p.local); ok {
+			p.read(x, n, v)
+		}
+	}
+
+	if n, v, ok := findProperty(descs, nsXMPBasic, "Rating"); ok {
+		if r, err := strconv.ParseFloat(strings.TrimSpace(propertyText(n, v)), 64); err == nil {
+			x.Rating = int(math.Round(r))
+		}
+	}
+
+	if n, _, ok := findProperty(descs, nsIptcCore, "CreatorContactInfo"); ok && n != nil {
+		body := []*xmlNode{n.structBody()}
+		for _, p := range contactFields {
+			if n, v, ok := findProperty(body, p.ns, p.local); ok {
+				p.read(x, n, v)
+			}
+		}
+	}
+
+	return x, nil
+}
+
+// Marshal returns x as an XMP packet. A parsed packet is updated in place, keeping the
+// properties and the layout it had; otherwise a new packet is written.
+func (x *XMP) Marshal() ([]byte, error) {
+	packet := x.packet
+	if packet == nil {
+		packet = []byte(xmpEmptyPacket)
+	}
+
+	doc, err := parseXMLTree(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := doc.descriptions()
+	if len(descs) == 0 {
+		return nil, fmt.Errorf("webp: XMP packet has no rdf:Description")
+	}
+
+	for _, p := range xmpProperties {
+		if p.text != nil {
+			setProperty(descs, p.ns, p.local, p.value, []string{*p.text(x)})
+		} else {
+			setProperty(descs, p.ns, p.local, p.value, *p.list(x))
+		}
+	}
+
+	rating := ""
+	if x.Rating != 0 {
+		rating = strconv.Itoa(x.Rating)
+	}
+	setProperty(descs, nsXMPBasic, "Rating", xmpText, []string{rating})
+
+	setContactInfo(descs, x)
+
+	var buf bytes.Buffer
+	for _, n := range doc.nodes {
+		n.write(&buf)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// read stores the value of the property element n, or the attribute value v when n is nil.
+func (p xmpProperty) read(x *XMP, n *xmlNode, v string) {
+	if p.text != nil {
+		*p.text(x) = propertyText(n, v)
+	} else {
+		*p.list(x) = propertyList(n, v)
+	}
+}
+
+// xmlNode is an element, or, when tok is set, character data, a comment or a processing
+// instruction. Names keep their prefixes; scope maps the prefixes in scope to namespaces.
+type xmlNode struct {
+	tok   xml.Token
+	name  xml.Name
+	attr  []xml.Attr
+	nodes []*xmlNode
+	scope map[string]string
+}
+
+// parseXMLTree parses data into a document node holding the top-level nodes.
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	doc := &xmlNode{scope: map[string]string{"xml": nsXML}}
+	stack := []*xmlNode{doc}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("webp: invalid XMP: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name, attr: append([]xml.Attr(nil), t.Attr...), scope: parent.scope}
+			n.declare()
+			parent.nodes = append(parent.nodes, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 1 || parent.name != t.Name {
+				return nil, fmt.Errorf("webp: invalid XMP: unexpected </%s>", qname(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			parent.nodes = append(parent.nodes, &xmlNode{tok: xml.CopyToken(tok)})
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("webp: invalid XMP: unclosed <%s>", qname(stack[len(stack)-1].name))
+	}
+
+	return doc, nil
+}
+
+// declare adds the namespace declarations among the attributes of n to its scope.
+func (n *xmlNode) declare() {
+	var scope map[string]string
+	for _, a := range n.attr {
+		prefix, ok := "", false
+		switch {
+		case a.Name.Space == "xmlns":
+			prefix, ok = a.Name.Local, true
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			ok = true
+		}
+
+		if ok {
+			if scope == nil {
+				scope = make(map[string]string, len(n.scope)+1)
+				for k, v := range n.scope {
+					scope[k] = v
+				}
+			}
+			scope[prefix] = a.Value
+		}
+	}
+
+	if scope != nil {
+		n.scope = scope
+	}
+}
+
+// is reports whether n is the element local in namespace ns.
+func (n *xmlNode) is(ns, local string) bool {
+	return n.tok == nil && n.name.Local == local && n.scope[n.name.Space] == ns
+}
+
+// attrIs reports whether the attribute a of n is local in namespace ns; unprefixed attributes
+// have no namespace.
+func (n *xmlNode) attrIs(a xml.Attr, ns, local string) bool {
+	return a.Name.Local == local && a.Name.Space != "" && a.Name.Space != "xmlns" && n.scope[a.Name.Space] == ns
+}
+
+func (n *xmlNode) attrValue(ns, local string) (string, bool) {
+	for _, a := range n.attr {
+		if n.attrIs(a, ns, local) {
+			return a.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// descriptions returns the rdf:Description elements of the rdf:RDF element.
+func (n *xmlNode) descriptions() []*xmlNode {
+	var descs []*xmlNode
+	for _, c := range n.nodes {
+		if c.tok != nil {
+			continue
+		}
+
+		if c.is(nsRDF, "RDF") {
+			for _, d := range c.nodes {
+				if d.is(nsRDF, "Description") {
+					descs = append(descs, d)
+				}
+			}
+		} else {
+			descs = append(descs, c.descriptions()...)
+		}
+	}
+
+	return descs
+}
+
+// child returns the first element child of n in namespace ns named one of names.
+func (n *xmlNode) child(ns string, names ...string) *xmlNode {
+	for _, c := range n.nodes {
+		for _, name := range names {
+			if c.is(ns, name) {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// text returns the character data directly inside n.
+func (n *xmlNode) text() string {
+	var sb strings.Builder
+	for _, c := range n.nodes {
+		if t, ok := c.tok.(xml.CharData); ok {
+			sb.Write(t)
+		}
+	}
+
+	return sb.String()
+}
+
+// structBody returns the node holding the fields of the struct property n: an inner
+// rdf:Description, or n itself for rdf:parseType="Resource" and attribute fields.
+func (n *xmlNode) structBody() *xmlNode {
+	if d := n.child(nsRDF, "Description"); d != nil {
+		return d
+	}
+
+	return n
+}
+
+// findProperty returns the first property ns:local of the descriptions, either its element or,
+// for the attribute form, a nil node and the attribute value.
+func findProperty(descs []*xmlNode, ns, local string) (*xmlNode, string, bool) {
+	for _, d := range descs {
+		if v, ok := d.attrValue(ns, local); ok {
+			return nil, v, true
+		}
+
+		if c := d.child(ns, local); c != nil {
+			return c, "", true
+		}
+	}
+
+	return nil, "", false
+}
+
+// propertyText returns a simple value, the x-default (or first) item of a language
+// alternative, or the first item of an array.
+func propertyText(n *xmlNode, v string) string {
+	if n == nil {
+		return v
+	}
+
+	if r, ok := n.attrValue(nsRDF, "resource"); ok {
+		return r
+	}
+
+	c := n.child(nsRDF, "Alt", "Seq", "Bag")
+	if c == nil {
+		return n.text()
+	}
+
+	var first *xmlNode
+	for _, li := range c.nodes {
+		if !li.is(nsRDF, "li") {
+			continue
+		}
+
+		if lang, _ := li.attrValue(nsXML, "lang"); lang == "x-default" {
+			return li.text()
+		}
+
+		if first == nil {
+			first = li
+		}
+	}
+
+	if first == nil {
+		return ""
+	}
+
+	return first.text()
+}
+
+// propertyList returns the items of an array, or a simple value as a single item.
+func propertyList(n *xmlNode, v string) []string {
+	if n != nil {
+		if c := n.child(nsRDF, "Seq", "Bag", "Alt"); c != nil {
+			var items []string
+			for _, li := range c.nodes {
+				if li.is(nsRDF, "li") {
+					items = append(items, li.text())
+				}
+			}
+
+			return items
+		}
+
+		v = n.text()
+		if strings.TrimSpace(v) == "" {
+			return nil
+		}
+	}
+
+	if v == "" {
+		return nil
+	}
+
+	return []string{v}
+}
+
+// setProperty sets the property ns:local of the descriptions to values, or removes it when the
+// values are empty. The first occurrence is updated in place and any further ones are removed;
+// a missing property is added to the first description.
+func setProperty(descs []*xmlNode, ns, local string, value xmpValue, values []string) {
+	empty := len(values) == 0 || (len(values) == 1 && values[0] == "")
+
+	found := false
+	for _, d := range descs {
+		attrs := d.attr[:0]
+		for _, a := range d.attr {
+			if !d.attrIs(a, ns, local) {
+				attrs = append(attrs, a)
+				continue
+			}
+
+			// A simple value stays an attribute; arrays and alternatives need an element.
+			if !found && !empty && value == xmpText {
+				a.Value = values[0]
+				attrs = append(attrs, a)
+				found = true
+			}
+		}
+		d.attr = attrs
+
+		nodes := d.nodes[:0]
+		for _, c := range d.nodes {
+			if !c.is(ns, local) {
+				nodes = append(nodes, c)
+				continue
+			}
+
+			if !found && !empty {
+				c.setValue(value, values)
+				nodes = append(nodes, c)
+				found = true
+			}
+		}
+		d.nodes = nodes
+	}
+
+	if found || empty {
+		return
+	}
+
+	d := descs[0]
+	prefix := d.prefix(ns)
+	c := &xmlNode{name: xml.Name{Space: prefix, Local: local}, scope: d.scope}
+	c.setValue(value, values)
+	d.nodes = append(d.nodes, c)
+}
+
+// setContactInfo updates the fields of Iptc4xmpCore:CreatorContactInfo, keeping other fields
+// of an existing struct, and removes the struct when no field is left.
+func setContactInfo(descs []*xmlNode, x *XMP) {
+	var n *xmlNode
+	for _, d := range descs {
+		if c := d.child(nsIptcCore, "CreatorContactInfo"); c != nil {
+			n = c
+			break
+		}
+	}
+
+	if n == nil {
+		if x.CreatorContactInfo == (ContactInfo{}) {
+			return
+		}
+
+		d := descs[0]
+		prefix, rdf := d.prefix(nsIptcCore), d.prefix(nsRDF)
+		n = &xmlNode{name: xml.Name{Space: prefix, Local: "CreatorContactInfo"}, scope: d.scope}
+		n.attr = []xml.Attr{{Name: xml.Name{Space: rdf, Local: "parseType"}, Value: "Resource"}}
+		d.nodes = append(d.nodes, n)
+	}
+
+	body := n.structBody()
+	for _, p := range contactFields {
+		setProperty([]*xmlNode{body}, p.ns, p.local, xmpText, []string{*p.text(x)})
+	}
+
+	// Remove a struct without fields; only the rdf:parseType and namespace attributes remain.
+	for _, a := range body.attr {
+		if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" && !body.attrIs(a, nsRDF, "parseType") && !body.attrIs(a, nsRDF, "about") {
+			return
+		}
+	}
+	for _, c := range body.nodes {
+		if c.tok == nil {
+			return
+		}
+	}
+
+	for _, d := range descs {
+		nodes := d.nodes[:0]
+		for _, c := range d.nodes {
+			if c != n {
+				nodes = append(nodes, c)
+			}
+		}
+		d.nodes = nodes
+	}
+}
+
+// setValue replaces the value of the property element n. A language alternative keeps its
+// other languages, and an array keeps its rdf:Seq or rdf:Bag container.
+func (n *xmlNode) setValue(value xmpValue, values []string) {
+	attrs := n.attr[:0]
+	for _, a := range n.attr {
+		if !n.attrIs(a, nsRDF, "resource") && !n.attrIs(a, nsRDF, "parseType") {
+			attrs = append(attrs, a)
+		}
+	}
+	n.attr = attrs
+
+	rdf := n.prefix(nsRDF)
+	li := func(v string) *xmlNode {
+		return &xmlNode{name: xml.Name{Space: rdf, Local: "li"}, nodes: []*xmlNode{{tok: xml.CharData(v)}}, scope: n.scope}
+	}
+
+	switch value {
+	case xmpText:
+		n.nodes = []*xmlNode{{tok: xml.CharData(values[0])}}
+	case xmpLang:
+		if alt := n.child(nsRDF, "Alt"); alt != nil {
+			for _, c := range alt.nodes {
+				if lang, _ := c.attrValue(nsXML, "lang"); c.is(nsRDF, "li") && lang == "x-default" {
+					c.nodes = []*xmlNode{{tok: xml.CharData(values[0])}}
+					return
+				}
+			}
+
+			// The x-default item comes first.
+			item := li(values[0])
+			item.attr = []xml.Attr{{Name: xml.Name{Space: "xml", Local: "lang"}, Value: "x-default"}}
+			alt.nodes = append([]*xmlNode{item}, alt.nodes...)
+
+			return
+		}
+
+		item := li(values[0])
+		item.attr = []xml.Attr{{Name: xml.Name{Space: "xml", Local: "lang"}, Value: "x-default"}}
+		n.nodes = []*xmlNode{{name: xml.Name{Space: rdf, Local: "Alt"}, nodes: []*xmlNode{item}, scope: n.scope}}
+	default:
+		container := xml.Name{Space: rdf, Local: "Seq"}
+		if value == xmpBag {
+			container.Local = "Bag"
+		}
+		if c := n.child(nsRDF, "Seq", "Bag"); c != nil {
+			container = c.name
+		}
+
+		items := make([]*xmlNode, len(values))
+		for i, v := range values {
+			items[i] = li(v)
+		}
+
+		n.nodes = []*xmlNode{{name: container, nodes: items, scope: n.scope}}
+	}
+}
+
+// prefix returns a prefix bound to ns in the scope of n, declaring one on n if there is none.
+func (n *xmlNode) prefix(ns string) string {
+	base := preferredPrefix[ns]
+	if base != "" && n.scope[base] == ns {
+		return base
+	}
+
+	for p, uri := range n.scope {
+		if uri == ns && p != "" {
+			return p
+		}
+	}
+
+	if base == "" {
+		base = "ns"
+	}
+
+	p := base
+	for i := 1; ; i++ {
+		if _, taken := n.scope[p]; !taken {
+			break
+		}
+		p = base + strconv.Itoa(i)
+	}
+
+	n.attr = append(n.attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: p}, Value: ns})
+	n.declare()
+
+	return p
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func qname(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// write serializes n; character data is escaped again, so entities and CDATA sections
+// come out in their escaped form.
+func (n *xmlNode) write(buf *bytes.Buffer) {
+	switch t := n.tok.(type) {
+	case xml.CharData:
+		buf.WriteString(xmlTextEscaper.Replace(string(t)))
+		return
+	case xml.Comment:
+		buf.WriteString("<!--")
+		buf.Write(t)
+		buf.WriteString("-->")
+		return
+	case xml.ProcInst:
+		buf.WriteString("<?" + t.Target)
+		if len(t.Inst) > 0 {
+			buf.WriteString(" ")
+			buf.Write(t.Inst)
+		}
+		buf.WriteString("?>")
+		return
+	case xml.Directive:
+		buf.WriteString("<!")
+		buf.Write(t)
+		buf.WriteString(">")
+		return
+	}
+
+	buf.WriteString("<" + qname(n.name))
+	for _, a := range n.attr {
+		buf.WriteString(" " + qname(a.Name) + `="` + xmlAttrEscaper.Replace(a.Value) + `"`)
+	}
+
+	if len(n.nodes) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+
+	buf.WriteString(">")
+	for _, c := range n.nodes {
+		c.write(buf)
+	}
+	buf.WriteString("</" + qname(n.name) + ">")
+}
@@ -0,0 +1,161 @@
+package webp
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testXMP = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:Iptc4xmpCore="http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+    xmlns:acme="http://example.com/acme/"
+    xmp:Rating="4" photoshop:Credit="Acme Photo" acme:AssetID="A-1">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Harbour</rdf:li><rdf:li xml:lang="de">Hafen</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>Ann</rdf:li><rdf:li>Bob</rdf:li></rdf:Seq></dc:creator>
+   <dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>boat</rdf:li></rdf:Bag></dc:subject>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default">(c) 2024 Acme &amp; Co</rdf:li></rdf:Alt></dc:rights>
+   <Iptc4xmpCore:Location>Pier 3</Iptc4xmpCore:Location>
+   <Iptc4xmpCore:CreatorContactInfo rdf:parseType="Resource">
+    <Iptc4xmpCore:CiEmailWork>ann@example.com</Iptc4xmpCore:CiEmailWork>
+    <acme:Desk>12</acme:Desk>
+   </Iptc4xmpCore:CreatorContactInfo>
+   <!-- reviewed -->
+   <acme:Workflow><rdf:Bag><rdf:li>approved</rdf:li></rdf:Bag></acme:Workflow>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestParseXMP(t *testing.T) {
+	x, err := ParseXMP([]byte(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := XMP{
+		Title:              "Harbour",
+		Creator:            []string{"Ann", "Bob"},
+		Subject:            []string{"sea", "boat"},
+		Rights:             "(c) 2024 Acme & Co",
+		Rating:             4,
+		Credit:             "Acme Photo",
+		Location:           "Pier 3",
+		CreatorContactInfo: ContactInfo{Email: "ann@example.com"},
+	}
+
+	got := *x
+	got.packet = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestXMPRoundTrip(t *testing.T) {
+	x, err := ParseXMP([]byte(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x.Title = "Harbor"
+	x.Creator = []string{"Carol"}
+	x.Subject = nil
+	x.Rating = 5
+	x.Credit = "Acme Photo Agency"
+	x.Headline = "Boats"
+	x.CountryCode = "NL"
+	x.CreatorContactInfo.Email = ""
+	x.CreatorContactInfo.City = "Rotterdam"
+
+	data, err := x.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	y, err := ParseXMP(data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+
+	x.packet, y.packet = nil, nil
+	if !reflect.DeepEqual(x, y) {
+		t.Errorf("got %+v, want %+v", y, x)
+	}
+
+	s := string(data)
+	for _, keep := range []string{`acme:AssetID="A-1"`, `<rdf:li xml:lang="de">Hafen</rdf:li>`, "<acme:Desk>12</acme:Desk>",
+		"<!-- reviewed -->", "<rdf:li>approved</rdf:li>", `<?xpacket end="w"?>`, `xmp:Rating="5"`} {
+		if !strings.Contains(s, keep) {
+			t.Errorf("packet lacks %q:\n%s", keep, s)
+		}
+	}
+	for _, gone := range []string{"dc:subject", "ann@example.com"} {
+		if strings.Contains(s, gone) {
+			t.Errorf("packet still has %q:\n%s", gone, s)
+		}
+	}
+}
+
+func TestXMPNew(t *testing.T) {
+	x := &XMP{
+		Title:              "Logo",
+		Creator:            []string{"Design Team"},
+		Rights:             "(c) Example <Inc>",
+		Rating:             -1,
+		Scene:              []string{"011900"},
+		CreatorContactInfo: ContactInfo{URL: "https://example.com"},
+	}
+
+	packet, err := x.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile("testdata/test.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeXMP(bytes.NewReader(data)); err != ErrNoXMP {
+		t.Errorf("err = %v, want ErrNoXMP", err)
+	}
+
+	var buf bytes.Buffer
+	if err := SetMetadata(&buf, bytes.NewReader(data), Metadata{XMP: packet}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeXMP(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got.packet = nil
+	if !reflect.DeepEqual(got, x) {
+		t.Errorf("got %+v, want %+v\n%s", got, x, packet)
+	}
+
+	// A cleared struct is removed.
+	got.CreatorContactInfo = ContactInfo{}
+	if packet, err = got.Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(packet, []byte("CreatorContactInfo")) {
+		t.Errorf("empty contact info written:\n%s", packet)
+	}
+}
+
+func TestParseXMPInvalid(t *testing.T) {
+	for _, s := range []string{"<x:xmpmeta>", "<a></b>", "<a>&bogus;</a>"} {
+		if _, err := ParseXMP([]byte(s)); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}